@@ -0,0 +1,97 @@
+// config.go - "config" subcommand: show and check the layered config.
+// Reports can quote real values (mappingsManual keys), so hook-bash denies it
+// when Claude tries to run it, like "mappings".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/abix-/claude-blueprints/sanitizer/internal"
+)
+
+// runConfig dispatches "sanitizer config <action> [flags]".
+func runConfig(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer config <show|validate> [flags]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "show":
+		runConfigShow(args[1:])
+	case "validate":
+		runConfigValidate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// runConfigShow lists the files and environment variables the config is
+// built from, or with -effective prints the merged result.
+func runConfigShow(args []string) {
+	fs := flag.NewFlagSet("config show", flag.ExitOnError)
+	effective := fs.Bool("effective", false, "print the merged config the hooks use")
+	fs.Parse(args)
+
+	log.SetOutput(io.Discard) // Load-time problems are for "config validate"
+	cfg := loadFullConfigOrExit()
+	if *effective {
+		out, err := cfg.EffectiveJSON()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "show error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	layers := cfg.Layers()
+	if len(layers) == 0 {
+		fmt.Printf("No config files; using defaults (%s does not exist)\n", internal.ConfigPath())
+		return
+	}
+	fmt.Println("Config layers, lowest precedence first:")
+	for _, l := range layers {
+		fmt.Printf("  %s\n", l)
+	}
+}
+
+// runConfigValidate prints every problem as file:line:col: path: message and
+// exits 1 if there are any. Like PowerShell: Test-Path, but for the whole config.
+func runConfigValidate(args []string) {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer config validate")
+		os.Exit(1)
+	}
+
+	log.SetOutput(io.Discard) // The load-time summary would repeat what's printed below
+	cfg := loadFullConfigOrExit()
+	problems, err := cfg.Validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "validate error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s)\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Printf("%s: OK\n", internal.ConfigPath())
+}
+
+// loadFullConfigOrExit skips the hooks' config snapshot (see snapshot.go): only
+// a full load knows which file and line each setting came from.
+func loadFullConfigOrExit() *internal.Config {
+	cfg, err := internal.LoadConfigFrom(internal.ConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
@@ -9,6 +9,8 @@
 //   - hook-post:          PostToolUse hook for sanitizing tool output
 //   - exec:               Run command with unsanitized values
 //   - sanitize-ips:       Pipe filter for IP sanitization
+//   - mappings:           Inspect the mapping store (see mappings.go)
+//   - config:             Validate sanitizer.json (see config.go)
 package main
 
 import (
@@ -22,7 +24,7 @@ import (
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "usage: sanitizer <command>")
-		fmt.Fprintln(os.Stderr, "commands: sanitize-ips, hook-file-access, hook-bash, hook-post, hook-session-start, hook-session-stop, exec")
+		fmt.Fprintln(os.Stderr, "commands: sanitize-ips, hook-file-access, hook-bash, hook-post, hook-session-start, hook-session-stop, exec, mappings, config")
 		os.Exit(1)
 	}
 
@@ -32,17 +34,21 @@ func main() {
 	case "sanitize-ips":
 		runSanitizeIPs()
 	case "hook-file-access":
-		runHook(internal.HookFileAccess)
+		runHook("hook-file-access", internal.HookFileAccess)
 	case "hook-bash":
-		runHook(internal.HookBash)
+		runHook("hook-bash", internal.HookBash)
 	case "hook-post":
-		runHook(internal.HookPostToolUse)
+		runHook("hook-post", internal.HookPostToolUse)
 	case "hook-session-start":
 		runSessionHook(internal.SessionStartCmd)
 	case "hook-session-stop":
 		runSessionHook(internal.SessionStopCmd)
 	case "exec":
 		runExec()
+	case "mappings":
+		runMappings(os.Args[2:])
+	case "config":
+		runConfig(os.Args[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
 		os.Exit(1)
@@ -69,30 +75,25 @@ func runSanitizeIPs() {
 	}
 
 	text := string(input)
-	discovered := internal.DiscoverSensitiveValues(text, cfg)
-	if len(discovered) > 0 {
-		autoMappings := cfg.MergeAutoMappings(discovered)
-		internal.SaveAutoMappings(autoMappings)
-		cfg.MappingsAuto = autoMappings
+	discovered := internal.DiscoverSensitiveValues(text, cfg, "sanitize-ips")
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
+	cfg.MappingsAuto = autoMappings
 
-	fmt.Print(internal.SanitizeText(text, cfg.AllMappings()))
+	fmt.Print(cfg.Sanitize(text, cfg.AllMappings()))
 }
 
 // runHook handles PreToolUse/PostToolUse hooks.
 // Claude Code sends JSON on stdin, expects JSON (or nothing) on stdout.
-// Exit 2 on error = fail closed (deny the operation).
-func runHook(fn func([]byte) ([]byte, error)) {
-	input, err := readStdin()
+// An error denies the call with the reason; a config or hook input error is
+// allowed instead if the hook's failureMode is open. See internal/failure.go.
+func runHook(name string, fn func([]byte) ([]byte, error)) {
+	output, err := callHook(fn)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "stdin error: %v\n", err)
-		os.Exit(2) // Fail closed
-	}
-
-	output, err := fn(input)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "hook error: %v\n", err)
-		os.Exit(2) // Fail closed
+		output = internal.HookFailure(name, err)
 	}
 
 	// nil output = no modification (allow as-is)
@@ -102,6 +103,22 @@ func runHook(fn func([]byte) ([]byte, error)) {
 	}
 }
 
+// callHook reads stdin and runs the hook. A panic becomes an error, so a bug
+// blocks the call like any other failure instead of crashing the hook.
+func callHook(fn func([]byte) ([]byte, error)) (output []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			output, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	input, err := readStdin()
+	if err != nil {
+		return nil, fmt.Errorf("stdin: %w", err)
+	}
+	return fn(input)
+}
+
 // runSessionHook handles session start/stop hooks.
 // These don't read stdin or produce output - they just do work.
 func runSessionHook(fn func() error) {
@@ -111,13 +128,18 @@ func runSessionHook(fn func() error) {
 }
 
 // runExec handles the "exec" subcommand for running commands with real values.
-// Usage: sanitizer exec '<command>'
+// Usage: sanitizer exec [--shell <interpreter>] '<command>'
 func runExec() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: sanitizer exec <command>")
+	args := os.Args[2:]
+	shell := ""
+	if len(args) >= 2 && args[0] == "--shell" {
+		shell, args = args[1], args[2:]
+	}
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer exec [--shell <interpreter>] <command>")
 		os.Exit(1)
 	}
-	if err := internal.Exec(os.Args[2]); err != nil {
+	if err := internal.Exec(args[0], shell); err != nil {
 		fmt.Fprintf(os.Stderr, "exec error: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,283 @@
+// mappings.go - "mappings" subcommand: inspect, prune, rotate, share and encrypt the mapping store.
+// Prints real values, so it is for a person at a terminal. hook-bash denies
+// it when Claude tries to run it.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/abix-/claude-blueprints/sanitizer/internal"
+)
+
+// runMappings dispatches "sanitizer mappings <action> [flags]".
+func runMappings(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer mappings <list|gc|rotate|merge|export|import|encrypt|decrypt> [flags]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		runMappingsList(args[1:])
+	case "gc":
+		runMappingsGC(args[1:])
+	case "rotate":
+		runMappingsRotate(args[1:])
+	case "merge":
+		runMappingsMerge(args[1:])
+	case "export":
+		runMappingsExport(args[1:])
+	case "import":
+		runMappingsImport(args[1:])
+	case "encrypt":
+		runMappingsEncrypt(args[1:])
+	case "decrypt":
+		runMappingsDecrypt(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mappings command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// runMappingsList prints store entries, filtered by flags.
+// Like PowerShell: Get-Mapping -Kind ip | Where-Object LastSeen -gt (Get-Date).AddDays(-7)
+func runMappingsList(args []string) {
+	fs := flag.NewFlagSet("mappings list", flag.ExitOnError)
+	kind := fs.String("kind", "", "only this kind (ip, hostname, ...)")
+	origin := fs.String("origin", "", "only this origin (manual, auto, import)")
+	project := fs.String("project", "", "only values first seen in or scoped to this project (ID or folder name)")
+	search := fs.String("search", "", "substring of real value, pseudonym or source")
+	days := fs.Int("days", 0, "only values seen in the last N days")
+	asJSON := fs.Bool("json", false, "print entries as JSON")
+	fs.Parse(args)
+
+	cfg := loadConfigOrExit()
+	store, err := cfg.MappingEntries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mapping store error: %v\n", err)
+		os.Exit(1)
+	}
+
+	query := internal.MappingQuery{Kind: *kind, Origin: *origin, Project: *project, Contains: *search}
+	if *days > 0 {
+		query.Since = time.Now().AddDate(0, 0, -*days)
+	}
+	entries := store.Query(query)
+
+	if *asJSON {
+		out, _ := json.MarshalIndent(entries, "", "    ")
+		fmt.Println(string(out))
+		return
+	}
+	printEntries(entries)
+}
+
+// printEntries writes entries as a table. tabwriter aligns columns, like Format-Table.
+func printEntries(entries []internal.MappingEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REAL\tPSEUDONYM\tKIND\tORIGIN\tSCOPE\tHITS\tFIRST SEEN\tPROJECT\tSOURCE")
+	for _, e := range entries {
+		scope := e.Scope
+		if scope == "" {
+			scope = internal.ScopeGlobal
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.Real, e.Pseudonym, e.Kind, e.Origin, scope, e.Hits, formatDate(e.FirstSeen), e.Project, e.Source)
+	}
+	w.Flush()
+}
+
+// runMappingsGC drops auto/import mappings not seen for -days.
+func runMappingsGC(args []string) {
+	fs := flag.NewFlagSet("mappings gc", flag.ExitOnError)
+	days := fs.Int("days", 0, "remove mappings not seen in this many days (required)")
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without changing the store")
+	fs.Parse(args)
+
+	if *days <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer mappings gc -days N [-dry-run]")
+		os.Exit(1)
+	}
+
+	cfg := loadConfigOrExit()
+	removed, err := cfg.PruneMappings(time.Now().AddDate(0, 0, -*days), *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc error: %v\n", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		printEntries(removed)
+		fmt.Printf("%d mapping(s) not seen in %d days would be removed\n", len(removed), *days)
+		return
+	}
+	fmt.Printf("Removed %d mapping(s) not seen in %d days\n", len(removed), *days)
+}
+
+// runMappingsRotate issues new pseudonyms for the selected values and
+// rewrites the current project's working tree to use them.
+// Like PowerShell: Get-Mapping -Kind hostname | Reset-Pseudonym
+func runMappingsRotate(args []string) {
+	fs := flag.NewFlagSet("mappings rotate", flag.ExitOnError)
+	all := fs.Bool("all", false, "rotate every auto and import mapping")
+	kind := fs.String("kind", "", "only this kind (ip, hostname, ...)")
+	project := fs.String("project", "", "only values first seen in or scoped to this project")
+	search := fs.String("search", "", "substring of real value, pseudonym or source")
+	fs.Parse(args)
+
+	query := internal.MappingQuery{Kind: *kind, Project: *project, Contains: *search, Values: fs.Args()}
+	if !*all && query.Kind == "" && query.Project == "" && query.Contains == "" && len(query.Values) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer mappings rotate <-all | -kind K | -project P | -search S | real value...>")
+		os.Exit(1)
+	}
+
+	cfg := loadConfigOrExit()
+	renames, err := cfg.RotateMappings(query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rotate error: %v\n", err)
+		os.Exit(1)
+	}
+
+	projectPath, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getwd: %v\n", err)
+		os.Exit(1)
+	}
+	files, err := cfg.RewriteProject(projectPath, renames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rewrite error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Rotated %d pseudonym(s), rewrote %d file(s) in %s\n", len(renames), files, projectPath)
+}
+
+// runMappingsMerge merges the project's shared mapping file with the local
+// store, in both directions. Run after pulling, and before committing.
+func runMappingsMerge(args []string) {
+	fs := flag.NewFlagSet("mappings merge", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg := loadConfigOrExit()
+	projectPath, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getwd: %v\n", err)
+		os.Exit(1)
+	}
+	result, err := cfg.MergeSharedMappings(projectPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "merge error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %d imported, %d exported, %d conflict(s) resolved\n",
+		cfg.SharedMappingsPath(projectPath), result.Imported, result.Exported, result.Conflicts)
+	if len(result.Renamed) > 0 {
+		fmt.Printf("Renamed %d local pseudonym(s), rewrote %d file(s)\n", len(result.Renamed), result.Files)
+	}
+}
+
+// runMappingsExport writes auto and import mappings as plaintext JSON, for
+// "mappings import" on another machine. Like PowerShell: Export-Clixml
+func runMappingsExport(args []string) {
+	fs := flag.NewFlagSet("mappings export", flag.ExitOnError)
+	file := fs.String("file", "", "write to this file (readable only by you) instead of stdout")
+	kind := fs.String("kind", "", "only this kind (ip, hostname, ...)")
+	project := fs.String("project", "", "only values first seen in or scoped to this project")
+	search := fs.String("search", "", "substring of real value, pseudonym or source")
+	fs.Parse(args)
+
+	cfg := loadConfigOrExit()
+	export, err := cfg.ExportMappings(internal.MappingQuery{Kind: *kind, Project: *project, Contains: *search})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export error: %v\n", err)
+		os.Exit(1)
+	}
+	if *file == "" {
+		out, _ := json.MarshalIndent(export, "", "    ")
+		fmt.Println(string(out))
+		return
+	}
+	if err := export.SaveTo(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "export error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported %d mapping(s) to %s\n", len(export.Entries), *file)
+}
+
+// runMappingsImport merges an export file, a copied mappings.json or an old
+// sanitizer.json into the local store. Like PowerShell: Import-Clixml
+func runMappingsImport(args []string) {
+	fs := flag.NewFlagSet("mappings import", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sanitizer mappings import <file>")
+		os.Exit(1)
+	}
+
+	cfg := loadConfigOrExit()
+	entries, counters, err := cfg.ReadMappingExport(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import error: %v\n", err)
+		os.Exit(1)
+	}
+	result, err := cfg.ImportMappings(entries, counters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d imported, %d already known, %d conflict(s) resolved\n", result.Imported, result.Known, result.Conflicts)
+}
+
+// runMappingsEncrypt encrypts the store (migrating a plaintext one) and turns
+// encryption on in sanitizer.json.
+func runMappingsEncrypt(args []string) {
+	fs := flag.NewFlagSet("mappings encrypt", flag.ExitOnError)
+	source := fs.String("key", "", "key source: keyfile, keyring or passphrase (default: config, else keyfile)")
+	fs.Parse(args)
+
+	cfg := loadConfigOrExit()
+	if *source == "" {
+		*source = cfg.Encryption.Key
+	}
+	if *source == "" {
+		*source = internal.KeySourceKeyfile
+	}
+	if err := cfg.EncryptMappingStore(*source); err != nil {
+		fmt.Fprintf(os.Stderr, "encrypt error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Mapping store encrypted (key: %s)\n", *source)
+}
+
+// runMappingsDecrypt writes the store back as plaintext.
+func runMappingsDecrypt(args []string) {
+	fs := flag.NewFlagSet("mappings decrypt", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg := loadConfigOrExit()
+	if err := cfg.DecryptMappingStore(); err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Mapping store decrypted")
+}
+
+func loadConfigOrExit() *internal.Config {
+	cfg, err := internal.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,227 @@
+// archive.go - Sanitization inside containers: zip, Office Open XML and gzip.
+// Runbooks (.docx), inventories (.xlsx) and rotated logs (.gz) are binary on
+// disk but mostly text inside. Text members are sanitized like any other file
+// (format-aware, any encoding); everything else is copied through untouched.
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions opened as zip containers. Office Open XML files are zips of XML parts.
+var zipExtensions = map[string]bool{
+	".zip":  true,
+	".docx": true, ".docm": true, ".dotx": true,
+	".xlsx": true, ".xlsm": true, ".xltx": true,
+	".pptx": true, ".pptm": true, ".potx": true,
+	".vsdx": true,
+}
+
+// Members with these extensions are never treated as text, even when they
+// contain no null bytes (compressed media, fonts, nested binaries).
+var opaqueMemberExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true, ".emf": true, ".wmf": true,
+	".bin": true, ".dll": true, ".exe": true, ".ttf": true, ".odttf": true, ".otf": true,
+	".mp3": true, ".mp4": true, ".wav": true, ".pdf": true,
+}
+
+// IsContainer reports whether path is an archive whose members get sanitized.
+func IsContainer(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return zipExtensions[ext] || ext == ".gz"
+}
+
+// TransformBytes applies fn to every piece of text in a file: zip members,
+// gzip payloads, or the file itself (decoded from its encoding and handled
+// format-aware). Containers are only rebuilt if a member changed; otherwise
+// the original bytes are returned so unchanged files are never rewritten.
+// Binary data that isn't a container is returned as-is.
+func TransformBytes(path string, data []byte, fn func(string) string) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case zipExtensions[ext]:
+		return transformZip(path, data, fn)
+	case ext == ".gz":
+		return transformGzip(path, data, fn)
+	}
+
+	text, enc, ok := DecodeText(data)
+	if !ok {
+		return data, nil
+	}
+	out := TransformContent(path, text, fn)
+	if out == text {
+		return data, nil
+	}
+	return EncodeText(out, enc), nil
+}
+
+// CollectText returns all the text TransformBytes would see, for discovery.
+// Structured files yield their decoded scalars, one per line.
+func CollectText(path string, data []byte) string {
+	var texts []string
+	TransformBytes(path, data, func(s string) string {
+		texts = append(texts, s)
+		return s
+	})
+	return strings.Join(texts, "\n")
+}
+
+// UnprocessedMember explains which part of a container TransformBytes leaves
+// as it is, though it may hold text: a member over MaxFileSize, a gzip payload
+// that is corrupt or too large once decompressed, or a file that isn't the
+// container its extension claims. Returns "" if everything was processed (or
+// path isn't a container). Binary members are left alone by design and don't
+// count. The Read hook uses it so denyUnprocessedReads covers these too.
+func UnprocessedMember(path string, data []byte) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case zipExtensions[ext]:
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return "not a readable zip"
+		}
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() || opaqueMemberExtensions[strings.ToLower(filepath.Ext(f.Name))] {
+				continue
+			}
+			if f.UncompressedSize64 > MaxFileSize {
+				return f.Name + " is larger than 10MB"
+			}
+			if IsContainer(f.Name) {
+				content, err := readZipMember(f)
+				if err != nil {
+					return f.Name + " can't be read"
+				}
+				if reason := UnprocessedMember(f.Name, content); reason != "" {
+					return f.Name + ": " + reason
+				}
+			}
+		}
+	case ext == ".gz":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "not a readable gzip"
+		}
+		defer zr.Close()
+		content, err := io.ReadAll(io.LimitReader(zr, MaxFileSize+1))
+		switch {
+		case err != nil:
+			return "corrupt gzip"
+		case len(content) > MaxFileSize:
+			return "larger than 10MB decompressed"
+		}
+		return UnprocessedMember(strings.TrimSuffix(path, filepath.Ext(path)), content)
+	}
+	return ""
+}
+
+func transformZip(path string, data []byte, fn func(string) string) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return data, nil // Not actually a zip - leave it alone
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	changed := false
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || opaqueMemberExtensions[strings.ToLower(filepath.Ext(f.Name))] ||
+			f.UncompressedSize64 > MaxFileSize {
+			if err := w.Copy(f); err != nil { // Raw copy, no recompression
+				return nil, err
+			}
+			continue
+		}
+
+		content, err := readZipMember(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, f.Name, err)
+		}
+		if len(content) > MaxFileSize {
+			return nil, fmt.Errorf("%s: %s: larger than its header claims", path, f.Name)
+		}
+		out, err := TransformBytes(f.Name, content, fn)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if bytes.Equal(out, content) {
+			if err := w.Copy(f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		changed = true
+		header := f.FileHeader // Same name, method, timestamps, attributes
+		mw, err := w.CreateHeader(&header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := mw.Write(out); err != nil {
+			return nil, err
+		}
+	}
+
+	if !changed {
+		return data, nil
+	}
+	if err := w.SetComment(r.Comment); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readZipMember(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// LimitReader guards against a member lying about its size (zip bomb).
+	// One byte over the limit lets the caller tell a truncated read apart.
+	return io.ReadAll(io.LimitReader(rc, MaxFileSize+1))
+}
+
+func transformGzip(path string, data []byte, fn func(string) string) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return data, nil
+	}
+	defer zr.Close()
+
+	content, err := io.ReadAll(io.LimitReader(zr, MaxFileSize+1))
+	if err != nil || len(content) > MaxFileSize {
+		return data, nil // Corrupt or too large once decompressed - skip
+	}
+
+	// Inner name decides format handling: app.json.gz is JSON
+	out, err := TransformBytes(strings.TrimSuffix(path, filepath.Ext(path)), content, fn)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(out, content) {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Header = zr.Header // Keep original name, mtime and comment
+	if _, err := zw.Write(out); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
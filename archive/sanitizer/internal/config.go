@@ -4,34 +4,153 @@ package internal
 
 import (
 	"encoding/json"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 )
 
 // Config holds all sanitizer settings.
 // The `json:"..."` tags tell Go how to map struct fields to JSON keys.
 // In PowerShell terms: like [Parameter()] attributes but for serialization.
 type Config struct {
+	// Version is the schema the file was written for (see migrate.go).
+	// Older files are upgraded on load.
+	Version int `json:"version"`
+
 	MappingsManual   map[string]string `json:"mappingsManual"`
 	MappingsAuto     map[string]string `json:"mappingsAuto"`
 	SkipPaths        []string          `json:"skipPaths"`
 	HostnamePatterns []string          `json:"hostnamePatterns"`
 	UnsanitizedPath  string            `json:"unsanitizedPath"`
 	BlockedPaths     []string          `json:"blockedPaths"`
+
+	// IPInclude and IPExclude are CIDRs (or single addresses) for IP discovery,
+	// most specific match wins (see ip.go). A non-empty IPInclude is an
+	// allowlist: IPs outside it are left alone.
+	IPInclude []string `json:"ipInclude"`
+	IPExclude []string `json:"ipExclude"`
+
+	// RespectGitignore leaves files .gitignore ignores alone, like
+	// .sanitizerignore (which always applies, see ignore.go).
+	RespectGitignore bool `json:"respectGitignore"`
+
+	// PathRules change detection for files matching their globs (see pathrules.go).
+	PathRules []PathRule `json:"pathRules"`
+
+	// Profile picks a sensitivity profile (minimal, standard, strict) that
+	// fills in the settings no layer sets (see profile.go).
+	Profile string `json:"profile"`
+	// DisableDetectors lists detector kinds that never run, like a pathRules
+	// disable for every file and tool output.
+	DisableDetectors []string `json:"disableDetectors"`
+
+	// FailureMode is open (allow) or closed (deny) for a hook that hits an
+	// internal error; HookFailureModes overrides it per hook (see failure.go).
+	FailureMode      string            `json:"failureMode"`
+	HookFailureModes map[string]string `json:"hookFailureModes"`
+
+	// BashRoutes send Bash commands to an action by regex, first match wins,
+	// before the built-in PowerShell routes (see hook_bash.go).
+	BashRoutes []BashRoute `json:"bashRoutes"`
+
+	// PseudonymTemplates maps a kind ("ip", "hostname") to an output template
+	// such as "server-{n:03}.corp.example". Kinds without a template get random values.
+	PseudonymTemplates map[string]string `json:"pseudonymTemplates"`
+	// Counters holds the last {n} issued per kind. Saved next to mappingsAuto.
+	Counters map[string]int `json:"counters"`
+
+	// SecretPatterns maps extra detector kinds to regexes, e.g.
+	// "password": "password\\s*=\\s*(\\S+)". Group 1, if present, is the secret.
+	SecretPatterns map[string]string `json:"secretPatterns"`
+	// Policies maps a kind to pseudonymize, redact or hash (see detect.go).
+	Policies map[string]string `json:"policies"`
+	// NotebookOutputs is keep, strip or redact for rich .ipynb outputs (see notebook.go).
+	NotebookOutputs string `json:"notebookOutputs"`
+
+	// DenyUnprocessedReads denies Read of in-project files that could not be
+	// sanitized (binary, too large, symlink, skipped). ReadDenyExceptions lists
+	// globs (see glob.go) for files that are safe to read unsanitized anyway.
+	DenyUnprocessedReads bool     `json:"denyUnprocessedReads"`
+	ReadDenyExceptions   []string `json:"readDenyExceptions"`
+
+	// Encryption turns on at-rest encryption of mappings.json (see crypt.go).
+	Encryption StoreEncryption `json:"encryption"`
+
+	// Projects holds per-project settings keyed by project path (see project.go).
+	// MappingScope is global or project: whether new mappings apply everywhere
+	// or only in the project that found them.
+	Projects     map[string]ProjectSettings `json:"projects"`
+	MappingScope string                     `json:"mappingScope"`
+
+	// SharedMappings locates the team mapping file committed with a project (see shared.go).
+	SharedMappings SharedMappingsConfig `json:"sharedMappings"`
+
+	// Unexported fields aren't serialized - runtime state only.
+	path       string               // Global file this config was loaded from; mappings.json sits next to it
+	sightings  map[string]*sighting // Detector matches since the last save (see store.go)
+	legacyAuto map[string]string    // Non-nil if sanitizer.json still holds mappingsAuto/counters to move to the store
+
+	projectID     string          // Project in the working directory (see project.go)
+	scope         string          // Store scope new mappings go to: "" = global, else projectID
+	projectManual map[string]bool // mappingsManual keys that came from the project's entry
+
+	retired  map[string]string // Rotated-out pseudonym -> real value (see rotate.go)
+	restored map[string]bool   // Real values restored from pseudonyms since the last save
+
+	manualStored map[string]string // mappingsManual key -> pseudonym of its store entry (see sightings.go)
+
+	layers  []*configLayer           // Files and env vars merged into this config (see layers.go)
+	origins map[string]settingOrigin // JSON path in the merged config -> where it was set
+
+	ignore *ignoreMatcher // .gitignore/.sanitizerignore patterns read so far (see ignore.go)
+
+	storeEncrypted bool   // mappings.json is encrypted: never snapshotted (see snapshot.go)
+	loadProblems   string // Summary of validate's problems, logged at load
 }
 
+// DefaultUnsanitizedPath keys the unsanitized copy on the project ID, so two
+// projects with the same folder name don't share one.
+const DefaultUnsanitizedPath = "~/.claude/unsanitized/{projectId}"
+
+// legacyUnsanitizedPath was the default (and what older installs wrote to
+// sanitizer.json) before project IDs; it is upgraded on load (see migrate.go).
+const legacyUnsanitizedPath = "~/.claude/unsanitized/{project}"
+
 var DefaultSkipPaths = []string{".git", ".claude", "node_modules", ".venv", "__pycache__"}
 
 // DefaultBlockedPaths are paths Claude should never access (contain real values or mappings).
 // Patterns are matched against paths normalized to forward slashes.
 var DefaultBlockedPaths = []string{
 	`\.claude/sanitizer/sanitizer\.json$`,
+	`\.claude/sanitizer/[^/]+\.key$`,
+	`\.claude/sanitizer/mappings\.json$`,
 	`\.claude/unsanitized/`,
 }
 
+// blockedPathEnd ends a builtin pattern: the end of a file path, or where a
+// path stops inside a Bash command ("cat store.key | head"). A plain $ would
+// only match a command that ends with the path.
+const blockedPathEnd = `($|[\s"';|&<>)])`
+
+// builtinBlockedPaths are always blocked, even if blockedPaths in an older
+// config doesn't list them: files holding real values added after that config was written.
+var builtinBlockedPaths = []*regexp.Regexp{
+	regexp.MustCompile(`\.claude/sanitizer/[^/\s"']+\.key` + blockedPathEnd),
+	regexp.MustCompile(`\.claude/sanitizer/(sanitizer|mappings)\.json` + blockedPathEnd),
+	regexp.MustCompile(`\.claude/sanitizer/mappings\.sightings` + blockedPathEnd),
+	regexp.MustCompile(`\.claude/sanitizer/shared-mappings\.txt` + blockedPathEnd),
+	regexp.MustCompile(`\.claude/sanitizer/cache/`),      // Config snapshots hold every mapping (see snapshot.go)
+	regexp.MustCompile(`(^|[/\s"'])\.sanitizer\.json\b`), // Project-local config (see layers.go)
+	regexp.MustCompile(`\.claude/sanitizer\.json\b`),
+	regexp.MustCompile(`(^|[/\s"'])\.sanitizerignore\b`), // Would let Claude exempt files (see ignore.go)
+}
+
+// gitignoreBlockedPath is blocked when respectGitignore is on: Claude could
+// add a file to a .gitignore to keep its edits out of the session stop sync.
+var gitignoreBlockedPath = regexp.MustCompile(`(^|[/\s"'])\.gitignore\b`)
+
 // stripBOM removes UTF-8 BOM that Windows apps (notepad, VS Code) add to files.
 // Without this, json.Unmarshal fails on files saved with BOM.
 func stripBOM(data []byte) []byte {
@@ -41,16 +160,34 @@ func stripBOM(data []byte) []byte {
 	return data
 }
 
+// SanitizerDir holds sanitizer.json, the mapping store and the binary
+// (see platform.go for how ClaudeDir is found on each OS).
 func SanitizerDir() string {
-	return filepath.Join(os.Getenv("USERPROFILE"), ".claude", "sanitizer")
+	return filepath.Join(ClaudeDir(), "sanitizer")
 }
 
 func ConfigPath() string {
 	return filepath.Join(SanitizerDir(), "sanitizer.json")
 }
 
+// LoadConfig loads the config the hooks use. A snapshot from an earlier call
+// is reused while none of its files have changed (see snapshot.go); otherwise
+// it's a full LoadConfigFrom, and the result is snapshotted for next time.
 func LoadConfig() (*Config, error) {
-	return LoadConfigFrom(ConfigPath())
+	path := ConfigPath()
+	projectPath, err := os.Getwd()
+	if err != nil || os.Getenv(NoCacheEnv) != "" {
+		return LoadConfigFrom(path)
+	}
+	if cfg := loadCachedConfig(path, projectPath); cfg != nil {
+		return cfg, nil
+	}
+	cfg, err := LoadConfigFrom(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.saveConfigSnapshot(projectPath)
+	return cfg, nil
 }
 
 // LoadConfigFrom reads config from disk, returning defaults if file doesn't exist.
@@ -62,26 +199,29 @@ func LoadConfigFrom(path string) (*Config, error) {
 	cfg := &Config{
 		MappingsManual:   make(map[string]string),
 		MappingsAuto:     make(map[string]string),
+		Counters:         make(map[string]int),
 		SkipPaths:        DefaultSkipPaths,
 		HostnamePatterns: []string{},
-		UnsanitizedPath:  "~/.claude/unsanitized/{project}",
+		UnsanitizedPath:  DefaultUnsanitizedPath,
 		BlockedPaths:     DefaultBlockedPaths,
+		path:             path,
 	}
 
-	data, err := os.ReadFile(path)
+	// Global file, its includes, project-local files, environment (see layers.go).
+	// A missing global file = use defaults, not an error.
+	raw, err := cfg.loadLayers()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(raw)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return cfg, nil // File doesn't exist = use defaults, not an error
-		}
 		return nil, err
 	}
-
-	data = stripBOM(data)
 
 	// json.Unmarshal populates cfg in-place. If JSON has a field, it overwrites
 	// the default. If JSON is missing a field, the default remains.
 	if err := json.Unmarshal(data, cfg); err != nil {
-		return nil, err
+		return nil, err // Each layer was checked on its own; this is a layer mix-up
 	}
 
 	// JSON null or missing field results in nil map, which panics on assignment.
@@ -92,10 +232,65 @@ func LoadConfigFrom(path string) (*Config, error) {
 	if cfg.MappingsAuto == nil {
 		cfg.MappingsAuto = make(map[string]string)
 	}
+	if cfg.Counters == nil {
+		cfg.Counters = make(map[string]int)
+	}
+
+	// Older configs kept discovered mappings here; they move to the store on next save
+	if len(cfg.MappingsAuto) > 0 || len(cfg.Counters) > 0 {
+		cfg.legacyAuto = make(map[string]string, len(cfg.MappingsAuto))
+		for real, pseudonym := range cfg.MappingsAuto {
+			cfg.legacyAuto[real] = pseudonym
+		}
+	}
 
+	cfg.applyProject()
+	store, err := cfg.LoadMappingStore()
+	if err != nil {
+		return nil, err
+	}
+	cfg.applyMappingStore(store)
+	cfg.storeEncrypted = store.key != nil
+
+	// Report, don't fail: hooks skip what they can't use, as before
+	if problems := cfg.validate(store, nil, false); len(problems) > 0 {
+		cfg.loadProblems = problemSummary(problems)
+		log.Print(cfg.loadProblems)
+	}
 	return cfg, nil
 }
 
+// loadMappingStore re-reads the store into the config after changing it.
+func (c *Config) loadMappingStore() error {
+	store, err := c.LoadMappingStore()
+	if err != nil {
+		return err
+	}
+	c.applyMappingStore(store)
+	return nil
+}
+
+// applyMappingStore adds the store's mappings for this project's scope and the
+// counters to the config. Store entries win over legacy mappingsAuto; counters
+// take the higher value.
+func (c *Config) applyMappingStore(store *MappingStore) {
+	for real, pseudonym := range store.MappingsFor(c.scope) {
+		c.MappingsAuto[real] = pseudonym
+	}
+	c.retired = store.RetiredFor(c.scope)
+	c.manualStored = make(map[string]string)
+	for _, e := range store.Entries {
+		if _, ok := c.MappingsManual[e.Real]; ok && e.Origin == OriginManual && e.Scope == c.manualScope(e.Real) {
+			c.manualStored[e.Real] = e.Pseudonym
+		}
+	}
+	for kind, n := range store.Counters {
+		if n > c.Counters[kind] {
+			c.Counters[kind] = n
+		}
+	}
+}
+
 // AllMappings merges auto + manual mappings. Manual wins on conflict.
 // (c *Config) is a "receiver" - makes this a method on Config type.
 // Like PowerShell: $config.AllMappings() instead of Get-AllMappings -Config $config
@@ -109,9 +304,21 @@ func (c *Config) AllMappings() map[string]string {
 	for k, v := range c.MappingsManual {
 		all[k] = v
 	}
+	c.addRetired(all)
 	return all
 }
 
+// addRetired maps rotated-out pseudonyms to the current ones, so text still
+// holding an old pseudonym (another project's working tree, or Claude quoting
+// an earlier transcript) is brought up to date.
+func (c *Config) addRetired(all map[string]string) {
+	for old, real := range c.retired {
+		if current, ok := all[real]; ok {
+			all[old] = current
+		}
+	}
+}
+
 // MergeAutoMappings combines existing auto mappings with newly discovered ones.
 func (c *Config) MergeAutoMappings(new map[string]string) map[string]string {
 	merged := make(map[string]string)
@@ -125,24 +332,43 @@ func (c *Config) MergeAutoMappings(new map[string]string) map[string]string {
 }
 
 // ReverseMappings flips keys/values for unsanitizing (sanitized -> original).
+// Rotated-out pseudonyms still restore to their real value. If two real values
+// share a pseudonym ("config validate" reports it), the lowest one wins, so
+// the result at least doesn't change from run to run.
 func (c *Config) ReverseMappings() map[string]string {
 	reverse := make(map[string]string)
-	for unsanitized, sanitized := range c.AllMappings() {
-		reverse[sanitized] = unsanitized
+	for old, real := range c.retired {
+		reverse[old] = real
+	}
+	for _, layer := range []map[string]string{c.MappingsAuto, c.MappingsManual} {
+		flipped := make(map[string]string, len(layer))
+		for unsanitized, sanitized := range layer {
+			if prev, ok := flipped[sanitized]; !ok || unsanitized < prev {
+				flipped[sanitized] = unsanitized
+			}
+		}
+		for sanitized, unsanitized := range flipped {
+			reverse[sanitized] = unsanitized // Manual wins, as in AllMappings
+		}
 	}
 	return reverse
 }
 
-// ExpandUnsanitizedPath resolves ~ and {project} placeholders.
-func (c *Config) ExpandUnsanitizedPath(projectName string) string {
-	path := c.UnsanitizedPath
-	if path == "" {
-		path = "~/.claude/unsanitized/{project}"
-	}
-	if len(path) > 0 && path[0] == '~' {
-		path = filepath.Join(os.Getenv("USERPROFILE"), path[1:])
+// ExpandUnsanitizedPath resolves ~, {projectId} (folder-hash, see project.go)
+// and {project} (folder name only) for the project at projectPath.
+func (c *Config) ExpandUnsanitizedPath(projectPath string) string {
+	template := c.UnsanitizedPath
+	if template == "" {
+		template = DefaultUnsanitizedPath
 	}
-	return filepath.Clean(strings.Replace(path, "{project}", projectName, 1))
+	return expandUnsanitizedPath(template, c.ProjectID(projectPath), projectPath)
+}
+
+func expandUnsanitizedPath(template, projectID, projectPath string) string {
+	path := expandHome(template)
+	path = strings.ReplaceAll(path, "{projectId}", projectID)
+	path = strings.ReplaceAll(path, "{project}", filepath.Base(projectPath))
+	return filepath.Clean(path)
 }
 
 // BuildAllMappings creates final mapping set from provided auto + config's manual.
@@ -155,15 +381,31 @@ func (c *Config) BuildAllMappings(autoMappings map[string]string) map[string]str
 	for k, v := range c.MappingsManual {
 		all[k] = v
 	}
+	c.addRetired(all)
 	return all
 }
 
-// BlockedPathRegexes compiles blockedPaths into regexp objects.
+// BlockedPathRegexes compiles blockedPaths into regexp objects, plus the built-in ones.
 // Returns compiled patterns, skipping any that fail to compile.
 func (c *Config) BlockedPathRegexes() []*regexp.Regexp {
-	var patterns []*regexp.Regexp
+	patterns := append([]*regexp.Regexp{}, builtinBlockedPaths...)
+	if c.RespectGitignore {
+		patterns = append(patterns, gitignoreBlockedPath)
+	}
+	if dir := strings.TrimSuffix(filepath.ToSlash(ClaudeDir()), "/"); !strings.HasSuffix(dir, "/.claude") {
+		// CLAUDE_CONFIG_DIR elsewhere: the builtins only match .claude/...
+		prefix := "(?i)" + regexp.QuoteMeta(dir)
+		patterns = append(patterns,
+			regexp.MustCompile(prefix+`/sanitizer/([^/\s"']+\.key|sanitizer\.json|mappings\.(json|sightings))`+blockedPathEnd),
+			regexp.MustCompile(prefix+`/sanitizer/cache/`),
+			regexp.MustCompile(prefix+`/unsanitized/`))
+	}
+	if p := c.SharedMappings.Path; p != "" {
+		p = strings.TrimPrefix(filepath.ToSlash(p), "./")
+		patterns = append(patterns, regexp.MustCompile(regexp.QuoteMeta(p)+blockedPathEnd))
+	}
 	for _, p := range c.BlockedPaths {
-		re, err := regexp.Compile(p)
+		re, err := compileCached(p)
 		if err != nil {
 			continue // Skip invalid patterns
 		}
@@ -172,74 +414,36 @@ func (c *Config) BlockedPathRegexes() []*regexp.Regexp {
 	return patterns
 }
 
-func SaveAutoMappings(autoMappings map[string]string) error {
-	return SaveAutoMappingsTo(ConfigPath(), autoMappings)
-}
-
-func lockPath(configPath string) string {
-	return configPath + ".lock"
-}
-
-// acquireLock creates an exclusive lock file. Uses O_EXCL flag which fails if
-// file exists - atomic "create if not exists". Retries for 5 seconds, then
-// assumes stale lock and forces.
-func acquireLock(configPath string) (*os.File, error) {
-	lock := lockPath(configPath)
-	for i := 0; i < 50; i++ {
-		// O_EXCL = fail if file exists (atomic check-and-create)
-		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
-		if err == nil {
-			return f, nil
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-	// Stale lock (crashed process) - force remove and retry
-	os.Remove(lock)
-	return os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
-}
-
-func releaseLock(f *os.File, configPath string) {
-	f.Close()
-	os.Remove(lockPath(configPath))
-}
-
-// SaveAutoMappingsTo persists auto-mappings while preserving other config fields.
-// Uses file locking to prevent race conditions from concurrent sanitizer instances.
-func SaveAutoMappingsTo(path string, autoMappings map[string]string) error {
+// updateConfigFile edits sanitizer.json as a raw map under the config lock, so
+// fields this version doesn't know about survive. No-op if the file is missing.
+func updateConfigFile(path string, edit func(raw map[string]any)) error {
 	lock, err := acquireLock(path)
 	if err != nil {
 		return err
 	}
-	// defer = "run this when function exits" (like try/finally).
-	// Guarantees lock release even if we return early on error.
-	defer releaseLock(lock, path)
+	defer releaseLock(lock)
 
 	data, err := os.ReadFile(path)
-	if err != nil && !os.IsNotExist(err) {
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
-
-	data = stripBOM(data)
-
-	// Unmarshal into map[string]any to preserve unknown fields.
-	// If we used Config struct, we'd lose any extra fields user added.
 	var raw map[string]any
-	if len(data) > 0 {
-		if err := json.Unmarshal(data, &raw); err != nil {
-			return err
-		}
-	} else {
+	if err := json.Unmarshal(stripBOM(data), &raw); err != nil {
+		return err
+	}
+	if raw == nil {
 		raw = make(map[string]any)
 	}
-
-	raw["mappingsAuto"] = autoMappings
+	edit(raw)
 
 	out, err := json.MarshalIndent(raw, "", "    ")
 	if err != nil {
 		return err
 	}
-
-	return os.WriteFile(path, out, 0644)
+	return writeFileAtomic(path, out, 0600)
 }
 
 // InitializeConfigIfNeeded creates default config if none exists.
@@ -251,12 +455,12 @@ func InitializeConfigIfNeeded() error {
 	}
 
 	os.MkdirAll(SanitizerDir(), 0755)
-	os.MkdirAll(filepath.Join(os.Getenv("USERPROFILE"), ".claude", "unsanitized"), 0755)
+	os.MkdirAll(filepath.Join(ClaudeDir(), "unsanitized"), 0755)
 
 	// Example config with placeholder values user should replace
 	cfg := map[string]any{
+		"version":          ConfigVersion,
 		"hostnamePatterns": []string{"[a-zA-Z0-9.-]+\\.domain\\.local"},
-		"mappingsAuto":     map[string]string{},
 		"mappingsManual": map[string]string{
 			"server.example.test": "server.example.test",
 			"111.50.100.1":        "111.50.100.1",
@@ -264,7 +468,7 @@ func InitializeConfigIfNeeded() error {
 			"projectname":         "projectname",
 		},
 		"skipPaths":       DefaultSkipPaths,
-		"unsanitizedPath": "~/.claude/unsanitized/{project}",
+		"unsanitizedPath": DefaultUnsanitizedPath,
 		"blockedPaths":    DefaultBlockedPaths,
 	}
 
@@ -273,5 +477,5 @@ func InitializeConfigIfNeeded() error {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	return os.WriteFile(path, data, 0600) // Manual mappings are real values
 }
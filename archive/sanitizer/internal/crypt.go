@@ -0,0 +1,310 @@
+// crypt.go - At-rest encryption for the mapping store.
+// mappings.json holds every real value the sanitizer has seen. With encryption
+// on, it is stored as an AES-256-GCM envelope and only decrypted in memory by
+// the hooks. The key comes from a key file, the OS keyring or a passphrase.
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Key sources, set via "encryption": {"key": ...} in sanitizer.json.
+const (
+	KeySourceKeyfile    = "keyfile"    // 32 random bytes in ~/.claude/sanitizer/store.key
+	KeySourceKeyring    = "keyring"    // Windows DPAPI, macOS Keychain, Linux Secret Service
+	KeySourcePassphrase = "passphrase" // SANITIZER_PASSPHRASE env var, stretched with PBKDF2
+)
+
+// PassphraseEnv holds the passphrase for the passphrase key source. Claude's
+// Bash commands inherit it along with the hooks, so it keeps the store from
+// other readers, not from Claude: hook-bash blocks commands that name it, but
+// env still lists it.
+const PassphraseEnv = "SANITIZER_PASSPHRASE"
+
+const (
+	storeCipher = "AES-256-GCM"
+	storeAAD    = "claude-sanitizer/mappings/v1" // Ties ciphertext to its purpose
+
+	// Every hook is a new process, so this is paid per tool call (~60ms).
+	// Stored in the envelope so it can be raised without breaking old stores.
+	pbkdf2Iterations = 200_000
+)
+
+// StoreEncryption is the "encryption" config block.
+type StoreEncryption struct {
+	Key     string `json:"key"`               // keyfile, keyring or passphrase; "" = plaintext
+	KeyFile string `json:"keyFile,omitempty"` // Key file path for keyfile (default ~/.claude/sanitizer/store.key)
+}
+
+// storeKey is a loaded key plus what's needed to write it back into an envelope.
+type storeKey struct {
+	source     string
+	key        []byte
+	salt       []byte // passphrase only
+	iterations int    // passphrase only
+}
+
+// encryptedStore is mappings.json when encrypted. []byte fields marshal as base64.
+type encryptedStore struct {
+	Encrypted envelopeHeader `json:"encrypted"`
+	Nonce     []byte         `json:"nonce"`
+	Data      []byte         `json:"data"`
+}
+
+type envelopeHeader struct {
+	Cipher     string `json:"cipher"`
+	Key        string `json:"key"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+// parseEnvelope returns the envelope if data is an encrypted store.
+func parseEnvelope(data []byte) (*encryptedStore, bool) {
+	if !bytes.Contains(data, []byte(`"encrypted"`)) {
+		return nil, false // Fast path for plaintext stores
+	}
+	var env encryptedStore
+	if err := json.Unmarshal(data, &env); err != nil || env.Encrypted.Cipher == "" {
+		return nil, false
+	}
+	return &env, true
+}
+
+func (e StoreEncryption) keyFilePath() string {
+	if e.KeyFile == "" {
+		return filepath.Join(SanitizerDir(), "store.key")
+	}
+	return expandHome(e.KeyFile)
+}
+
+// openKey loads the key an envelope was written with. Never creates one.
+func (e StoreEncryption) openKey(h envelopeHeader) (*storeKey, error) {
+	switch h.Key {
+	case KeySourceKeyfile:
+		key, err := readKeyFile(e.keyFilePath())
+		if err != nil {
+			return nil, fmt.Errorf("mapping store key file: %w", err)
+		}
+		return &storeKey{source: h.Key, key: key}, nil
+	case KeySourceKeyring:
+		key, err := keyringGet()
+		if err != nil {
+			return nil, fmt.Errorf("mapping store keyring: %w", err)
+		}
+		return &storeKey{source: h.Key, key: key}, nil
+	case KeySourcePassphrase:
+		return passphraseKey(h.Salt, h.Iterations)
+	}
+	return nil, fmt.Errorf("mapping store: unknown key source %q", h.Key)
+}
+
+// newKey returns a key for encrypting with source, creating the key file or
+// keyring entry if there isn't one yet.
+func (e StoreEncryption) newKey(source string) (*storeKey, error) {
+	switch source {
+	case KeySourceKeyfile:
+		path := e.keyFilePath()
+		if key, err := readKeyFile(path); err == nil {
+			return &storeKey{source: source, key: key}, nil
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		os.MkdirAll(filepath.Dir(path), 0755)
+		// O_EXCL: never overwrite a key file - that would orphan the store
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s exists but is unreadable or invalid: %w", path, err)
+		}
+		defer f.Close()
+		if _, err := f.Write([]byte(hex.EncodeToString(key) + "\n")); err != nil {
+			return nil, err
+		}
+		return &storeKey{source: source, key: key}, nil
+	case KeySourceKeyring:
+		if key, err := keyringGet(); err == nil {
+			return &storeKey{source: source, key: key}, nil
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := keyringSet(key); err != nil {
+			return nil, fmt.Errorf("keyring: %w", err)
+		}
+		return &storeKey{source: source, key: key}, nil
+	case KeySourcePassphrase:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		return passphraseKey(salt, pbkdf2Iterations)
+	}
+	return nil, fmt.Errorf("unknown key source %q (use keyfile, keyring or passphrase)", source)
+}
+
+// readKeyFile reads a hex-encoded 32-byte key.
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s: not a 64-character hex key", path)
+	}
+	return key, nil
+}
+
+// Derived passphrase keys, cached so load + save in one hook only stretch once.
+var passphraseKeys sync.Map
+
+func passphraseKey(salt []byte, iterations int) (*storeKey, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return nil, fmt.Errorf("mapping store is passphrase-encrypted: set %s", PassphraseEnv)
+	}
+	if len(salt) == 0 || iterations <= 0 {
+		return nil, fmt.Errorf("mapping store: passphrase envelope missing salt or iterations")
+	}
+	cacheKey := fmt.Sprintf("%x:%d", salt, iterations)
+	if key, ok := passphraseKeys.Load(cacheKey); ok {
+		return &storeKey{source: KeySourcePassphrase, key: key.([]byte), salt: salt, iterations: iterations}, nil
+	}
+	key := pbkdf2SHA256([]byte(passphrase), salt, iterations, 32)
+	passphraseKeys.Store(cacheKey, key)
+	return &storeKey{source: KeySourcePassphrase, key: key, salt: salt, iterations: iterations}, nil
+}
+
+// pbkdf2SHA256 is PBKDF2 (RFC 8018) with HMAC-SHA256. crypto/pbkdf2 needs Go 1.24.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+// sealStore encrypts a plaintext store into an envelope.
+func sealStore(plain []byte, k *storeKey) ([]byte, error) {
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	env := encryptedStore{
+		Encrypted: envelopeHeader{Cipher: storeCipher, Key: k.source, Salt: k.salt, Iterations: k.iterations},
+		Nonce:     nonce,
+		Data:      gcm.Seal(nil, nonce, plain, []byte(storeAAD)),
+	}
+	return json.MarshalIndent(env, "", "    ")
+}
+
+// openStore decrypts an envelope. A wrong key and a tampered file look the same.
+func openStore(env *encryptedStore, k *storeKey) ([]byte, error) {
+	if env.Encrypted.Cipher != storeCipher {
+		return nil, fmt.Errorf("mapping store: unsupported cipher %q", env.Encrypted.Cipher)
+	}
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, env.Nonce, env.Data, []byte(storeAAD))
+	if err != nil {
+		return nil, fmt.Errorf("mapping store: wrong key or corrupted file")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptMappingStore rewrites mappings.json encrypted with a key from source
+// (creating the key if needed) and records the choice in sanitizer.json, so
+// later saves keep encrypting. Migrates a plaintext store, or an older config's
+// mappingsAuto, in one step. Re-running with another source re-keys the store.
+func (c *Config) EncryptMappingStore(source string) error {
+	if _, err := c.SaveAutoMappings(c.MappingsAuto); err != nil { // Flush legacy mappingsAuto first
+		return err
+	}
+	key, err := c.Encryption.newKey(source)
+	if err != nil {
+		return err
+	}
+	if err := c.rewriteMappingStore(key); err != nil {
+		return err
+	}
+	c.Encryption.Key = source
+	return updateConfigFile(c.path, func(raw map[string]any) {
+		raw["encryption"] = c.Encryption
+	})
+}
+
+// DecryptMappingStore writes mappings.json back as plaintext and turns encryption off.
+func (c *Config) DecryptMappingStore() error {
+	if err := c.rewriteMappingStore(nil); err != nil {
+		return err
+	}
+	c.Encryption.Key = ""
+	return updateConfigFile(c.path, func(raw map[string]any) {
+		delete(raw, "encryption")
+	})
+}
+
+func (c *Config) rewriteMappingStore(key *storeKey) error {
+	return c.updateMappingStore(func(store *MappingStore) bool {
+		store.key = key
+		return true
+	})
+}
+
+// encodeKeyringSecret/decodeKeyringSecret store the key as text, since
+// keyring tools deal in passwords rather than bytes.
+func encodeKeyringSecret(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func decodeKeyringSecret(secret string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(secret))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("keyring entry is not a sanitizer key")
+	}
+	return key, nil
+}
@@ -0,0 +1,319 @@
+// detect.go - Detectors for sensitive values and per-kind replacement policies.
+// Each detector finds values of one kind (ip, hostname, privatekey, ...). The
+// kind's policy decides whether a value gets a reversible pseudonym or an
+// irreversible token that is never stored or restored.
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Replacement policies, set per kind via the "policies" config field.
+const (
+	PolicyPseudonymize = "pseudonymize" // Reversible: stored in mappings, restored on exec/stop
+	PolicyRedact       = "redact"       // Fixed [REDACTED:kind] token, never stored or restored
+	PolicyHash         = "hash"         // Keyed hash token, never stored or restored
+)
+
+var (
+	// PEM private keys. [\s\S] spans lines, including JSON-escaped "\n" sequences.
+	privateKeyRegex = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+	// 13-19 digit card numbers with optional space/dash grouping. Leading digit
+	// limited to issuer ranges (2-6) to keep timestamps and IDs out; Luhn checked below.
+	cardRegex = regexp.MustCompile(`\b[2-6](?:[ -]?[0-9]){12,18}\b`)
+)
+
+// Detector finds candidate sensitive values of one kind in text.
+type Detector struct {
+	Kind string
+	Find func(text string) []string
+}
+
+// Detectors returns every active detector: IPs, configured hostname patterns,
+// built-in secrets (private keys, opt-in card numbers) and configured secretPatterns.
+// Invalid patterns are skipped.
+func (c *Config) Detectors() []Detector {
+	ips := c.newIPFilter()
+	detectors := []Detector{
+		{Kind: "ip", Find: func(text string) []string { return findIPs(text, ips) }},
+	}
+
+	detectors = append(detectors, hostnameDetector(c.HostnamePatterns))
+
+	detectors = append(detectors,
+		Detector{Kind: "privatekey", Find: func(text string) []string { return privateKeyRegex.FindAllString(text, -1) }},
+	)
+
+	// Card detection is opt-in (give "card" a policy): long numeric IDs pass
+	// the Luhn check one time in ten, and redaction can't be undone.
+	if _, ok := c.Policies["card"]; ok {
+		detectors = append(detectors, Detector{Kind: "card", Find: findCards})
+	}
+
+	for kind, pattern := range c.SecretPatterns {
+		re, err := compileCached(pattern)
+		if err != nil {
+			continue
+		}
+		detectors = append(detectors, Detector{Kind: kind, Find: findGroup(re)})
+	}
+
+	if len(c.DisableDetectors) == 0 {
+		return detectors
+	}
+	var active []Detector
+	for _, d := range detectors {
+		if !kindListed(c.DisableDetectors, d.Kind) {
+			active = append(active, d)
+		}
+	}
+	return active
+}
+
+// hostnameDetector matches hostname patterns: user has full regex control,
+// only (?i) prepended.
+func hostnameDetector(patterns []string) Detector {
+	var hostnameRegexes []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := compileCached(`(?i)` + pattern)
+		if err != nil {
+			continue
+		}
+		hostnameRegexes = append(hostnameRegexes, re)
+	}
+	return Detector{Kind: "hostname", Find: func(text string) []string {
+		var matches []string
+		for _, re := range hostnameRegexes {
+			for _, m := range re.FindAllString(text, -1) {
+				if m != "" { // A pattern like x* also matches nothing, everywhere
+					matches = append(matches, m)
+				}
+			}
+		}
+		return matches
+	}}
+}
+
+func findIPs(text string, filter *ipFilter) []string {
+	var ips []string
+	for _, ip := range ipv4Regex.FindAllString(text, -1) {
+		if filter.hides(ip) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func findCards(text string) []string {
+	var cards []string
+	for _, match := range cardRegex.FindAllString(text, -1) {
+		if luhnValid(match) {
+			cards = append(cards, match)
+		}
+	}
+	return cards
+}
+
+// luhnValid runs the card-number checksum over the digits in s.
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue // Grouping space/dash
+		}
+		d := int(s[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// findGroup returns capture group 1 when the pattern has one (so
+// `password\s*=\s*(\S+)` yields only the secret), else the whole match.
+func findGroup(re *regexp.Regexp) func(string) []string {
+	return func(text string) []string {
+		var values []string
+		for _, m := range re.FindAllStringSubmatch(text, -1) {
+			if len(m) > 1 && m[1] != "" {
+				values = append(values, m[1])
+			} else if len(m) > 1 {
+				continue // Optional group didn't participate - nothing to hide
+			} else if m[0] != "" {
+				values = append(values, m[0])
+			}
+		}
+		return values
+	}
+}
+
+// PolicyFor returns the replacement policy for a detector kind.
+// IPs and hostnames default to pseudonymize; secrets default to redact.
+func (c *Config) PolicyFor(kind string) string {
+	if policy, ok := c.Policies[kind]; ok && policy != "" {
+		return policy
+	}
+	if kind == "ip" || kind == "hostname" {
+		return PolicyPseudonymize
+	}
+	return PolicyRedact
+}
+
+// Redactions finds values in text whose kind is redacted or hashed and returns
+// value -> token. These are computed fresh from the text every time and never
+// saved, so the real value does not need to exist anywhere in the store.
+// Manual mappings win (identity mappings keep protecting false positives).
+func (c *Config) Redactions(text string) map[string]string {
+	return c.redactions(c.irreversible(c.Detectors()), text)
+}
+
+// irreversible keeps the detectors whose kind is redacted or hashed.
+func (c *Config) irreversible(all []Detector) []Detector {
+	var detectors []Detector
+	for _, d := range all {
+		if c.PolicyFor(d.Kind) != PolicyPseudonymize {
+			detectors = append(detectors, d)
+		}
+	}
+	return detectors
+}
+
+// irreversibleTokenRegex matches the tokens irreversibleToken writes. A
+// detector that matches one ("password = [HASH:password:...]") is looking at
+// its own earlier output; hashing that again would change the token on every pass.
+var irreversibleTokenRegex = regexp.MustCompile(`\[(?:REDACTED:[^\]\s]+|HASH:[^\]\s:]+:[0-9a-f]{16})\]`)
+
+func (c *Config) redactions(detectors []Detector, text string) map[string]string {
+	redactions := make(map[string]string)
+	for _, d := range detectors {
+		policy := c.PolicyFor(d.Kind)
+		for _, match := range d.Find(text) {
+			if _, manual := c.MappingsManual[match]; manual {
+				continue
+			}
+			if irreversibleTokenRegex.MatchString(match) {
+				continue // Already a token: sanitizing is idempotent
+			}
+			redactions[match] = irreversibleToken(d.Kind, policy, match)
+		}
+	}
+	return redactions
+}
+
+// Sanitize replaces mapped values plus any redacted/hashed values found in text.
+func (c *Config) Sanitize(text string, mappings map[string]string) string {
+	return c.Sanitizer(mappings)(text)
+}
+
+// Sanitizer returns Sanitize as a reusable function with detectors compiled
+// and keys sorted once - for format handlers that call it per scalar value.
+// Redactions run first and so override auto mappings: switching a kind to
+// "redact" also stops reversible pseudonyms issued for it earlier.
+func (c *Config) Sanitizer(mappings map[string]string) func(string) string {
+	return c.sanitizeWith(c.irreversible(c.Detectors()), newReplacer(mappings))
+}
+
+// FileSanitizer is Sanitizer for the file at path: its pathRules decide which
+// redacting detectors run. Mapped values are replaced everywhere regardless.
+func (c *Config) FileSanitizer(path string, mappings map[string]string) func(string) string {
+	return c.fileSanitizer(path, newReplacer(mappings))
+}
+
+// fileSanitizer is FileSanitizer with the replacer built once by the caller,
+// for session start, which sanitizes every file with the same mappings.
+func (c *Config) fileSanitizer(path string, replace func(string) string) func(string) string {
+	return c.sanitizeWith(c.irreversible(c.DetectorsFor(ProjectRelative(path))), replace)
+}
+
+func (c *Config) sanitizeWith(detectors []Detector, replace func(string) string) func(string) string {
+	return func(text string) string {
+		if redactions := c.redactions(detectors, text); len(redactions) > 0 {
+			text = SanitizeText(text, redactions)
+		}
+		return replace(text)
+	}
+}
+
+// irreversibleToken builds the replacement for a redacted or hashed value.
+// Hash tokens are stable (same secret = same token) so readers can still tell
+// two secrets apart, but keyed so low-entropy values can't be brute-forced.
+func irreversibleToken(kind, policy, value string) string {
+	if policy == PolicyHash {
+		if key, err := hashKey(); err == nil {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(kind + ":" + value))
+			return fmt.Sprintf("[HASH:%s:%s]", kind, hex.EncodeToString(mac.Sum(nil))[:16])
+		}
+		// No key available - a redaction is still irreversible
+	}
+	return fmt.Sprintf("[REDACTED:%s]", kind)
+}
+
+var (
+	hashKeyOnce  sync.Once
+	hashKeyBytes []byte
+	hashKeyErr   error
+)
+
+func hashKeyPath() string {
+	return filepath.Join(SanitizerDir(), "hash.key")
+}
+
+// hashKey loads the local HMAC key for hash tokens, creating it on first use.
+func hashKey() ([]byte, error) {
+	hashKeyOnce.Do(func() {
+		path := hashKeyPath()
+		if data, err := os.ReadFile(path); err == nil && len(data) >= 32 {
+			hashKeyBytes = data
+			return
+		}
+		key := make([]byte, 32)
+		if _, hashKeyErr = rand.Read(key); hashKeyErr != nil {
+			return
+		}
+		os.MkdirAll(filepath.Dir(path), 0755)
+		// O_EXCL: if another hook created the key first, use theirs so tokens agree
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			hashKeyBytes, hashKeyErr = os.ReadFile(path)
+			return
+		}
+		defer f.Close()
+		if _, hashKeyErr = f.Write(key); hashKeyErr != nil {
+			return
+		}
+		hashKeyBytes = key
+	})
+	return hashKeyBytes, hashKeyErr
+}
+
+// compiledRegexps holds every pattern compiled in this process, so loading
+// the config twice (or building detectors per file) compiles each once.
+var compiledRegexps sync.Map // pattern -> *regexp.Regexp
+
+// compileCached is regexp.Compile, memoized for the life of the process.
+func compileCached(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compiledRegexps.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,141 @@
+// encoding.go - Text encoding detection for UTF-8, UTF-8 with BOM and UTF-16.
+// PowerShell's Out-File, regedit exports and many Windows tools write UTF-16LE,
+// which is full of null bytes and would otherwise look binary. Files are decoded
+// to a Go string for sanitization and written back in their original encoding.
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// TextEncoding records how a text file was stored so it can be written back
+// byte-compatible (same byte order, same BOM).
+type TextEncoding struct {
+	Name string // "utf-8", "utf-16le" or "utf-16be"
+	BOM  bool
+}
+
+var (
+	EncodingUTF8    = TextEncoding{Name: "utf-8"}
+	EncodingUTF16LE = TextEncoding{Name: "utf-16le"}
+	EncodingUTF16BE = TextEncoding{Name: "utf-16be"}
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// DetectEncoding identifies the encoding from a BOM, or for BOM-less UTF-16
+// from the null byte pattern: mostly-ASCII UTF-16LE text has a zero in nearly
+// every odd byte and almost none in even bytes (mirrored for big-endian).
+// sample can be a prefix of the file. Returns ok=false for data that looks binary.
+func DetectEncoding(sample []byte) (enc TextEncoding, ok bool) {
+	switch {
+	case bytes.HasPrefix(sample, bomUTF8):
+		return TextEncoding{Name: "utf-8", BOM: true}, bytes.IndexByte(sample, 0) < 0
+	case bytes.HasPrefix(sample, bomUTF16LE):
+		return TextEncoding{Name: "utf-16le", BOM: true}, true
+	case bytes.HasPrefix(sample, bomUTF16BE):
+		return TextEncoding{Name: "utf-16be", BOM: true}, true
+	}
+
+	zeroEven, zeroOdd := 0, 0
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			zeroEven++
+		} else {
+			zeroOdd++
+		}
+	}
+	if zeroEven == 0 && zeroOdd == 0 {
+		return EncodingUTF8, true
+	}
+
+	// Need enough text to judge, a clear one-sided pattern, and no U+0000
+	// (a zero in both bytes of a code unit) - those only appear in binary data.
+	pairs := len(sample) / 2
+	if pairs >= 4 && !hasZeroUnit(sample) {
+		if zeroOdd*10 >= pairs*3 && zeroEven*20 <= pairs {
+			return EncodingUTF16LE, true
+		}
+		if zeroEven*10 >= pairs*3 && zeroOdd*20 <= pairs {
+			return EncodingUTF16BE, true
+		}
+	}
+	return TextEncoding{}, false
+}
+
+// DecodeText converts file bytes to a string. The BOM is not part of the text.
+// Returns ok=false if the data is not text in a supported encoding.
+func DecodeText(data []byte) (string, TextEncoding, bool) {
+	enc, ok := DetectEncoding(data)
+	if !ok {
+		return "", enc, false
+	}
+
+	switch enc.Name {
+	case "utf-16le", "utf-16be":
+		if enc.BOM {
+			data = data[2:]
+		}
+		if len(data)%2 != 0 {
+			return "", enc, false // Truncated or not really UTF-16
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if enc.Name == "utf-16be" {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[i*2:])
+		}
+		return string(utf16.Decode(units)), enc, true
+	default:
+		if enc.BOM {
+			data = data[len(bomUTF8):]
+		}
+		return string(data), enc, true
+	}
+}
+
+// EncodeText converts text back to bytes in the given encoding, restoring the BOM.
+func EncodeText(text string, enc TextEncoding) []byte {
+	switch enc.Name {
+	case "utf-16le", "utf-16be":
+		var order binary.AppendByteOrder = binary.LittleEndian
+		bom := bomUTF16LE
+		if enc.Name == "utf-16be" {
+			order, bom = binary.BigEndian, bomUTF16BE
+		}
+		units := utf16.Encode([]rune(text))
+		out := make([]byte, 0, len(units)*2+2)
+		if enc.BOM {
+			out = append(out, bom...)
+		}
+		for _, u := range units {
+			out = order.AppendUint16(out, u)
+		}
+		return out
+	default:
+		if enc.BOM {
+			return append(append([]byte{}, bomUTF8...), text...)
+		}
+		return []byte(text)
+	}
+}
+
+func hasZeroUnit(data []byte) bool {
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -9,10 +9,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 // Exec runs a command in the unsanitized directory with real values.
-// Called via: sanitizer.exe exec '<command>'
+// Called via: sanitizer exec [--shell <interpreter>] '<command>'
+// shell is "" for the platform default (see interpreterCommand).
 //
 // Steps:
 // 1. Sync working tree to unsanitized directory (reversing sanitization)
@@ -20,7 +22,7 @@ import (
 // 3. Execute command in unsanitized directory
 // 4. Sanitize output before printing (so Claude sees sanitized values)
 // 5. Preserve exit code (so command failures propagate correctly)
-func Exec(command string) error {
+func Exec(command, shell string) error {
 	cfg, err := LoadConfig()
 	if err != nil {
 		// fmt.Errorf with %w wraps the error, preserving the original.
@@ -33,8 +35,7 @@ func Exec(command string) error {
 		return fmt.Errorf("getwd: %w", err)
 	}
 
-	projectName := filepath.Base(projectPath)
-	unsanitizedPath := cfg.ExpandUnsanitizedPath(projectName)
+	unsanitizedPath := cfg.ExpandUnsanitizedPath(projectPath)
 
 	if err := os.MkdirAll(unsanitizedPath, 0755); err != nil {
 		return fmt.Errorf("mkdir %s: %w", unsanitizedPath, err)
@@ -44,17 +45,20 @@ func Exec(command string) error {
 	// Transform reverses sanitization: fake values -> real values.
 	reverseMappings := cfg.ReverseMappings()
 	transform := func(content string) string {
-		return UnsanitizeText(content, reverseMappings)
+		restored := UnsanitizeText(content, reverseMappings)
+		if restored != content {
+			cfg.recordRestored(content, reverseMappings) // Saved with the output's mappings below
+		}
+		return restored
 	}
-	_ = SyncDir(projectPath, unsanitizedPath, cfg.SkipPaths, transform)
+	_ = SyncDir(projectPath, unsanitizedPath, cfg, transform)
 
 	// The command Claude wrote uses sanitized values (e.g., 111.x.x.x).
 	// Unsanitize it so it references real infrastructure.
 	unsanitizedCmd := UnsanitizeText(command, reverseMappings)
 
-	// exec.Command creates a command but doesn't run it yet.
-	// -NoProfile skips loading PowerShell profile for faster startup.
-	cmd := exec.Command("powershell.exe", "-NoProfile", "-Command", unsanitizedCmd)
+	// interpreterCommand creates the command but doesn't run it yet
+	cmd := interpreterCommand(shell, unsanitizedCmd)
 	cmd.Dir = unsanitizedPath // Run in unsanitized directory
 
 	// Capture stdout and stderr separately into buffers.
@@ -71,16 +75,18 @@ func Exec(command string) error {
 
 	// Discover any new IPs/hostnames in output and save them.
 	// This ensures consistency - same real value always gets same sanitized value.
-	discovered := DiscoverSensitiveValues(output, cfg)
-	if len(discovered) > 0 {
-		autoMappings := cfg.MergeAutoMappings(discovered)
-		SaveAutoMappings(autoMappings)
-		cfg.MappingsAuto = autoMappings // Update in-memory for immediate use
+	discovered := DiscoverSensitiveValues(output, cfg, "exec")
+	autoMappings, saveErr := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if saveErr != nil {
+		// New values would reach Claude under pseudonyms that can't be restored
+		fmt.Println("[sanitizer: output withheld, new values found in it could not be saved]")
+		return saveErr
 	}
+	cfg.MappingsAuto = autoMappings // Update in-memory for immediate use
 
 	// Sanitize output so Claude doesn't see real values
 	allMappings := cfg.AllMappings()
-	sanitized := SanitizeText(output, allMappings)
+	sanitized := cfg.Sanitize(output, allMappings)
 
 	// Print to stdout (goes back to Claude via bash tool)
 	fmt.Print(sanitized)
@@ -97,3 +103,21 @@ func Exec(command string) error {
 
 	return nil
 }
+
+// interpreterCommand builds the command for a bashRoutes shell (see
+// hook_bash.go). "" is the platform default: PowerShell on Windows, pwsh or
+// /bin/sh elsewhere (see shell_windows.go, shell_unix.go). Other names pick
+// their arguments by the interpreter's file name, so a full path works too.
+func interpreterCommand(shell, command string) *exec.Cmd {
+	if shell == "" {
+		return shellCommand(command)
+	}
+	switch strings.ToLower(strings.TrimSuffix(filepath.Base(shell), ".exe")) {
+	case "pwsh", "powershell":
+		return exec.Command(shell, "-NoProfile", "-Command", command)
+	case "cmd":
+		return exec.Command(shell, "/d", "/c", command)
+	default:
+		return exec.Command(shell, "-c", command) // bash, sh, zsh, ...
+	}
+}
@@ -0,0 +1,126 @@
+// failure.go - What a hook does when it can't do its job: a config that won't
+// load, hook input it can't parse, a panic. Fail open (the default) allows the
+// tool call as if the sanitizer weren't installed; fail closed denies it, with
+// the reason. Like $ErrorActionPreference: Continue or Stop.
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Failure modes for failureMode and hookFailureModes.
+const (
+	FailureOpen   = "open"
+	FailureClosed = "closed"
+)
+
+// FailureModeEnv overrides failureMode; it also holds when sanitizer.json
+// can't be read at all.
+const FailureModeEnv = "SANITIZER_FAILURE_MODE"
+
+// FailureHooks are the hooks hookFailureModes can name: the CLI subcommands.
+var FailureHooks = []string{"hook-bash", "hook-file-access", "hook-post"}
+
+// failureSettings are the config fields that decide the failure mode.
+type failureSettings struct {
+	FailureMode      string            `json:"failureMode"`
+	HookFailureModes map[string]string `json:"hookFailureModes"`
+}
+
+// openableError marks the errors failureMode open may let through: the config
+// or the hook input couldn't be read, so the hook can't tell what to protect.
+// Any other error (stdin, a file or output that didn't get sanitized, a panic)
+// always blocks, as every error did before failureMode: open would hand
+// Claude whatever the hook was sanitizing, as it is.
+type openableError struct{ err error }
+
+func (e openableError) Error() string { return e.err.Error() }
+func (e openableError) Unwrap() error { return e.err }
+
+// configLoadError wraps a LoadConfig error returned by a hook.
+func configLoadError(err error) error {
+	return openableError{fmt.Errorf("config load: %w", err)}
+}
+
+// hookInputError wraps an error parsing the hook's JSON input (what names the part).
+func hookInputError(what string, err error) error {
+	return openableError{fmt.Errorf("%s: %w", what, err)}
+}
+
+// HookFailure is called by the CLI when a hook returns an error (or panics).
+// It logs the error and returns the response for the hook's failure mode:
+// nil to allow the tool call, or JSON that blocks it. Only an openableError
+// can be allowed; anything else blocks whatever the mode says.
+func HookFailure(hook string, hookErr error) []byte {
+	mode, why := failureModeFor(hook), "failureMode is closed"
+	var openable openableError
+	switch {
+	case errors.Is(hookErr, ErrMappingsNotSaved):
+		mode, why = FailureClosed, "new mappings could not be saved"
+	case !errors.As(hookErr, &openable):
+		mode, why = FailureClosed, "only config and hook input errors can fail open"
+	}
+	log.Printf("sanitizer: %s failed (failureMode %s): %v", hook, mode, hookErr)
+	if mode == FailureOpen {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Blocked: sanitizer %s failed and %s: %v", hook, why, hookErr)
+	var out []byte
+	if hook == "hook-post" {
+		// The tool already ran; what it can still do is keep the output from Claude
+		out, _ = json.Marshal(map[string]any{
+			"hookSpecificOutput": map[string]any{
+				"hookEventName": "PostToolUse",
+				"updatedOutput": "[" + reason + "; output withheld]",
+			},
+		})
+	} else {
+		out, _ = DenyResponse(reason)
+	}
+	return out
+}
+
+// failureModeFor resolves a hook's failure mode: hookFailureModes, then
+// failureMode, then open. When the config is what failed, its layers are
+// merged again without the rest of loading (a broken mapping store doesn't
+// stop that), profile and project files included, so strict still fails
+// closed. If the layers themselves won't merge, only the
+// environment override counts, and without it the mode is closed: a config
+// that can't be read can't say open.
+func failureModeFor(hook string) string {
+	var s failureSettings
+	if cfg, err := LoadConfig(); err == nil {
+		s = failureSettings{cfg.FailureMode, cfg.HookFailureModes}
+	} else if merged, err := (&Config{path: ConfigPath()}).loadLayers(); err == nil {
+		if data, err := json.Marshal(merged); err == nil {
+			_ = json.Unmarshal(data, &s) // Types were checked per file as it merged
+		}
+	} else {
+		s.FailureMode = os.Getenv(FailureModeEnv)
+		if s.FailureMode == "" {
+			return FailureClosed
+		}
+	}
+
+	if mode := s.HookFailureModes[hook]; mode != "" {
+		return normalizeFailureMode(mode)
+	}
+	if s.FailureMode != "" {
+		return normalizeFailureMode(s.FailureMode)
+	}
+	return FailureOpen
+}
+
+// normalizeFailureMode treats anything but "open" as closed: a typo
+// shouldn't quietly turn protection off (validate reports it).
+func normalizeFailureMode(mode string) string {
+	if mode == FailureOpen {
+		return FailureOpen
+	}
+	return FailureClosed
+}
@@ -3,6 +3,7 @@ package internal
 
 import (
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,6 +12,7 @@ import (
 const MaxFileSize = 10 * 1024 * 1024 // 10MB - skip large files to avoid memory issues
 
 // IsBinary checks if a file is binary by looking for null bytes in first 8KB.
+// Null bytes in the UTF-16 pattern (or after a UTF-16 BOM) are text, see DetectEncoding.
 // Returns true (assume binary) on any read error - safer to skip than corrupt.
 func IsBinary(path string) bool {
 	f, err := os.Open(path)
@@ -26,45 +28,71 @@ func IsBinary(path string) bool {
 		return true
 	}
 
-	// Null byte = binary file (images, executables, etc.)
-	for i := 0; i < n; i++ {
-		if buf[i] == 0 {
-			return true
-		}
-	}
-	return false
+	// Null byte outside UTF-16 = binary file (images, executables, etc.)
+	_, isText := DetectEncoding(buf[:n])
+	return !isText
 }
 
 // ShouldProcessFile determines if a file should be sanitized.
 // Skips: directories, empty files, large files, symlinks, binary files, excluded paths.
-func ShouldProcessFile(path string, info os.FileInfo, projectPath string, skipPaths []string) bool {
-	if info.IsDir() || info.Size() == 0 || info.Size() > MaxFileSize {
-		return false
+func ShouldProcessFile(path string, info os.FileInfo, projectPath string, cfg *Config) bool {
+	return FileSkipReason(path, info, projectPath, cfg) == ""
+}
+
+// FileSkipReason explains why a walk doesn't sanitize a file, or returns "" if
+// it does. Used by ShouldProcessFile. Excluded paths are skipPaths, ignored
+// files and pathRules with skip (see Config.skipReason).
+func FileSkipReason(path string, info os.FileInfo, projectPath string, cfg *Config) string {
+	return fileSkipReason(path, info, projectPath, cfg, true)
+}
+
+// readSkipReason is FileSkipReason for a file Claude asked for, used to tell
+// Claude why a Read was denied. Ignore files don't count: they say what isn't
+// worth walking, not what Claude may see unsanitized.
+func readSkipReason(path string, info os.FileInfo, projectPath string, cfg *Config) string {
+	return fileSkipReason(path, info, projectPath, cfg, false)
+}
+
+func fileSkipReason(path string, info os.FileInfo, projectPath string, cfg *Config, walk bool) string {
+	switch {
+	case info.IsDir():
+		return "directory"
+	case info.Size() == 0:
+		return "empty file"
+	case info.Size() > MaxFileSize:
+		return "larger than 10MB"
 	}
 
 	// Bitwise AND to check mode flags. os.ModeSymlink is a bit flag.
 	// Like PowerShell: ($item.Attributes -band [IO.FileAttributes]::ReparsePoint)
 	if info.Mode()&os.ModeSymlink != 0 {
-		return false
+		return "symlink"
 	}
 
 	// Ensure file is under project directory (prevent path traversal)
 	relPath, err := filepath.Rel(projectPath, path)
 	if err != nil || strings.HasPrefix(relPath, "..") {
-		return false
+		return "outside the project"
 	}
 
 	// Always skip .claude directory (config and unsanitized data)
 	normalizedRel := strings.ReplaceAll(relPath, "\\", "/")
 	if strings.HasPrefix(normalizedRel, ".claude/") || normalizedRel == ".claude" {
-		return false
+		return "in the .claude directory"
+	}
+	if isProjectConfigFile(normalizedRel) {
+		return "project sanitizer config" // Holds real values; sanitizing it would break it
 	}
 
-	if IsSkippedPath(relPath, skipPaths) {
-		return false
+	if reason := cfg.skipReason(projectPath, relPath, walk); reason != "" {
+		return reason
 	}
 
-	return !IsBinary(path)
+	// Archives and Office documents are binary but their members are sanitized
+	if !IsContainer(path) && IsBinary(path) {
+		return "binary file"
+	}
+	return ""
 }
 
 // IsSkippedPath checks if path matches any skip pattern (.git, node_modules, etc.)
@@ -84,18 +112,24 @@ func IsSkippedPath(relativePath string, skipPaths []string) bool {
 }
 
 // SyncDir copies srcDir to dstDir, optionally transforming text file content.
-// Binary files are copied as-is. Used to sync working tree <-> unsanitized directory.
+// Binary files are copied as-is; archives get their text members transformed. Used to sync working tree <-> unsanitized directory.
 //
 // transform is a function that takes file content and returns modified content.
 // Pass nil to copy without modification. Example: pass UnsanitizeText to restore
-// original values when syncing to unsanitized directory.
-func SyncDir(srcDir, dstDir string, skipPaths []string, transform func(string) string) error {
+// original values when syncing to unsanitized directory. It is applied
+// format-aware (see TransformContent), falling back to the whole text if the
+// structured result wouldn't parse.
+// Paths cfg skips (skipPaths, ignore files, pathRules with skip) are left out.
+func SyncDir(srcDir, dstDir string, cfg *Config, transform func(string) string) error {
 	// filepath.Walk recursively visits all files/dirs. Like Get-ChildItem -Recurse.
 	// The callback function is called for each item. Return nil to continue,
 	// return error to stop walking.
 	return filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return nil // Skip errors and directories, continue walking
+		if err != nil {
+			return nil // Skip errors, continue walking
+		}
+		if info.IsDir() {
+			return skipWalkDir(cfg, srcDir, path)
 		}
 
 		if info.Mode()&os.ModeSymlink != 0 {
@@ -107,7 +141,7 @@ func SyncDir(srcDir, dstDir string, skipPaths []string, transform func(string) s
 			return nil
 		}
 
-		if IsSkippedPath(relPath, skipPaths) {
+		if cfg.skipReason(srcDir, relPath, true) != "" {
 			return nil
 		}
 
@@ -122,25 +156,48 @@ func SyncDir(srcDir, dstDir string, skipPaths []string, transform func(string) s
 		}
 
 		// Binary files: copy bytes directly, no transformation
-		if IsBinary(path) {
+		if transform == nil || (IsBinary(path) && !IsContainer(path)) {
 			return copyFile(path, dstPath)
 		}
 
-		// Text files: read, transform, write
+		// Text files and containers: read, transform, write (in the file's own encoding)
 		content, err := os.ReadFile(path)
 		if err != nil {
 			return copyFile(path, dstPath) // Fallback to binary copy on read error
 		}
 
-		transformed := content
-		if transform != nil {
-			transformed = []byte(transform(string(content)))
+		transformed, err := TransformBytes(path, content, transform)
+		if err != nil {
+			transformed = content
+			if text, enc, ok := DecodeText(content); ok {
+				transformed = EncodeText(transform(text), enc) // Same result as before format handling
+			}
+		}
+
+		// Redacted and hashed values come back from the copy being replaced (see restore.go)
+		transformed, err = cfg.restoreTokens(path, dstPath, transformed)
+		if err != nil {
+			log.Printf("sanitizer: %v", err)
+			return nil // The copy keeps its real values
 		}
 
 		return os.WriteFile(dstPath, transformed, info.Mode())
 	})
 }
 
+// skipWalkDir returns filepath.SkipDir for a directory the walk needn't enter
+// (node_modules, an ignored build output), so big trees cost nothing.
+func skipWalkDir(cfg *Config, root, path string) error {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil || relPath == "." {
+		return nil
+	}
+	if cfg.skipDir(root, relPath) {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
 // copyFile does a binary copy using io.Copy (streams, doesn't load entire file).
 func copyFile(src, dst string) error {
 	srcF, err := os.Open(src)
@@ -0,0 +1,606 @@
+// format.go - Format-aware sanitization for JSON, YAML, XML and INI files.
+// Plain substring replacement can break structured files: a value with quotes or
+// backslashes isn't escaped, and a token like [REDACTED:password] turns a YAML
+// scalar into a list. These handlers apply the transform to each decoded scalar
+// (keys included), re-encode only the values that changed so the rest of the
+// file stays byte-identical, and check the result still parses.
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// formatHandler transforms the scalars of one file format.
+// transform returns ok=false if the input isn't valid for the format (caller
+// falls back to plain text). verify checks the output still parses.
+type formatHandler struct {
+	transform func(content string, fn func(string) string) (out string, ok bool)
+	verify    func(original, out string) error
+}
+
+var formatHandlers = map[string]formatHandler{
+	".json":  {transformJSON, verifyJSON},
+	".ipynb": {transformNotebook, verifyNotebook}, // See notebook.go
+
+	".yaml": {transformYAML, verifyYAML},
+	".yml":  {transformYAML, verifyYAML},
+
+	".xml":     {transformXML, verifyXML},
+	".config":  {transformXML, verifyXML},
+	".csproj":  {transformXML, verifyXML},
+	".props":   {transformXML, verifyXML},
+	".targets": {transformXML, verifyXML},
+	".xaml":    {transformXML, verifyXML},
+	".resx":    {transformXML, verifyXML},
+	".plist":   {transformXML, verifyXML},
+	".rels":    {transformXML, verifyXML}, // Office package relationships
+
+	".ini": {transformINI, verifyINI},
+	".cfg": {transformINI, verifyINI},
+}
+
+// TransformContent applies fn to the text in content. Files with a known
+// structured format are handled scalar by scalar; everything else (and content
+// that doesn't parse as its extension claims) gets fn applied to the whole text.
+// If the scalar-by-scalar result would no longer parse, the whole text is
+// transformed as plain text instead: the file may break, but leaving it as it
+// was would hand Claude the real values.
+func TransformContent(path, content string, fn func(string) string) string {
+	handler, ok := formatHandlers[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return fn(content)
+	}
+
+	out, ok := handler.transform(content, fn)
+	if !ok {
+		return fn(content) // Not valid for its format - treat as plain text
+	}
+	if err := handler.verify(content, out); err != nil {
+		log.Printf("sanitizer: %s: output does not parse (%v), transformed as plain text", path, err)
+		return fn(content)
+	}
+	return out
+}
+
+// ----------------------------------------------------------------------------
+// JSON
+// ----------------------------------------------------------------------------
+
+// transformJSON rewrites string tokens (keys and values) in place. Numbers,
+// literals and whitespace are never touched, so formatting is preserved exactly.
+func transformJSON(content string, fn func(string) string) (string, bool) {
+	if !json.Valid([]byte(content)) {
+		return "", false
+	}
+
+	var out strings.Builder
+	last := 0
+	for i := 0; i < len(content); i++ {
+		if content[i] != '"' {
+			continue
+		}
+		// Find the closing quote. Input is valid JSON, so every string terminates.
+		end := i + 1
+		for content[end] != '"' {
+			if content[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		token := content[i : end+1]
+
+		var value string
+		if err := json.Unmarshal([]byte(token), &value); err == nil {
+			if replaced := fn(value); replaced != value {
+				out.WriteString(content[last:i])
+				out.WriteString(encodeJSONString(replaced))
+				last = end + 1
+			}
+		}
+		i = end
+	}
+	out.WriteString(content[last:])
+	return out.String(), true
+}
+
+// encodeJSONString quotes s without Go's default HTML escaping (< as \u003c).
+func encodeJSONString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func verifyJSON(_, out string) error {
+	var v any
+	return json.Unmarshal([]byte(out), &v)
+}
+
+// ----------------------------------------------------------------------------
+// YAML
+// ----------------------------------------------------------------------------
+
+// There's no YAML parser in the standard library, so this is a line scanner
+// that understands the common block style: "key: value", "- item", comments,
+// quoted scalars and block scalars (| and >). Anything it doesn't recognize
+// (flow collections, multi-line quoted strings) gets fn applied to the raw text,
+// which is what plain sanitization did before.
+
+var (
+	// indent, optional "- " markers, key (quoted or plain), ':' + space or EOL
+	yamlKeyLine = regexp.MustCompile(`^(\s*(?:-\s+)*)("(?:[^"\\]|\\.)*"|'(?:[^']|'')*'|[^\s#'"\-\[\]{}][^#]*?|-[^\s#][^#]*?)(\s*:)(\s+|$)(.*)$`)
+	yamlSeqLine = regexp.MustCompile(`^(\s*(?:-\s+)+)(.*)$`)
+	// Block scalar header: value is | or > with optional chomping/indent indicators
+	yamlBlockHeader = regexp.MustCompile(`^[|>][-+0-9]*\s*(#.*)?$`)
+)
+
+func transformYAML(content string, fn func(string) string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	out := make([]string, 0, len(lines))
+	blockIndent := -1 // >= 0 while inside a block scalar
+	var body []string
+
+	for _, line := range lines {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		// Block scalar body: literal text, indented deeper than its key
+		if blockIndent >= 0 {
+			if strings.TrimSpace(line) == "" || indent > blockIndent {
+				body = append(body, line)
+				continue
+			}
+			out = append(out, yamlBlock(body, fn)...)
+			blockIndent, body = -1, nil
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || trimmed == "---" || trimmed == "...":
+			out = append(out, line)
+			continue
+		case strings.HasPrefix(trimmed, "#"):
+			out = append(out, fn(line)) // Comments can name hosts too
+			continue
+		}
+
+		if m := yamlKeyLine.FindStringSubmatch(line); m != nil {
+			prefix, key, colon, space, rest := m[1], m[2], m[3], m[4], m[5]
+			if yamlBlockHeader.MatchString(strings.TrimSpace(rest)) {
+				blockIndent = indent
+				out = append(out, prefix+yamlScalar(key, fn)+colon+space+rest)
+				continue
+			}
+			value, comment := splitYAMLComment(rest)
+			out = append(out, prefix+yamlScalar(key, fn)+colon+space+yamlScalar(value, fn)+fn(comment))
+			continue
+		}
+
+		if m := yamlSeqLine.FindStringSubmatch(line); m != nil {
+			if yamlBlockHeader.MatchString(strings.TrimSpace(m[2])) {
+				blockIndent = indent
+				out = append(out, line)
+				continue
+			}
+			value, comment := splitYAMLComment(m[2])
+			out = append(out, m[1]+yamlScalar(value, fn)+fn(comment))
+			continue
+		}
+
+		out = append(out, fn(line))
+	}
+	out = append(out, yamlBlock(body, fn)...)
+	return strings.Join(out, "\n"), true
+}
+
+// yamlBlock applies fn to a block scalar body as one value, so a detector
+// that spans lines (a PEM key) sees all of it, then indents the result as the
+// body was. An unchanged body is returned as it was, byte for byte.
+func yamlBlock(body []string, fn func(string) string) []string {
+	prefix := ""
+	indent := -1
+	for _, line := range body {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if n := len(line) - len(strings.TrimLeft(line, " \t")); indent < 0 || n < indent {
+			indent, prefix = n, line[:n]
+		}
+	}
+	if indent < 0 {
+		return body // Blank lines only
+	}
+
+	text := make([]string, len(body))
+	for i, line := range body {
+		if len(line) > indent {
+			text[i] = line[indent:]
+		}
+	}
+	value := strings.Join(text, "\n")
+	replaced := fn(value)
+	if replaced == value {
+		return body
+	}
+	out := strings.Split(replaced, "\n")
+	for i, line := range out {
+		if line != "" {
+			out[i] = prefix + line
+		}
+	}
+	return out
+}
+
+// splitYAMLComment separates "value  # comment" outside of quotes.
+// A # only starts a comment at the start or after whitespace.
+func splitYAMLComment(s string) (value, comment string) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && strings.TrimSpace(s[:i]) == "":
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			// Keep trailing whitespace with the comment so the value stays tight
+			v := strings.TrimRight(s[:i], " \t")
+			return v, s[len(v):]
+		}
+	}
+	return s, ""
+}
+
+// yamlScalar applies fn to one scalar, keeping its quoting style. A plain
+// scalar whose new value would change meaning is re-emitted double-quoted.
+func yamlScalar(s string, fn func(string) string) string {
+	trailing := s[len(strings.TrimRight(s, " \t")):]
+	s = strings.TrimRight(s, " \t")
+	if s == "" {
+		return trailing
+	}
+
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		value, ok := unquoteYAMLDouble(s)
+		if !ok {
+			return fn(s) + trailing
+		}
+		if replaced := fn(value); replaced != value {
+			return encodeJSONString(replaced) + trailing // JSON strings are valid YAML double-quoted
+		}
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		value := strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+		if replaced := fn(value); replaced != value {
+			return "'" + strings.ReplaceAll(replaced, "'", "''") + "'" + trailing
+		}
+	case s[0] == '[' || s[0] == '{' || s[0] == '&' || s[0] == '*' || s[0] == '!':
+		return fn(s) + trailing // Flow collection, anchor, alias or tag - leave structure to the text
+	default:
+		if replaced := fn(s); replaced != s {
+			if yamlNeedsQuotes(replaced) {
+				return encodeJSONString(replaced) + trailing
+			}
+			return replaced + trailing
+		}
+	}
+	return s + trailing
+}
+
+// yamlNeedsQuotes reports whether a plain scalar would parse as something other
+// than the same string (a list, a mapping, a comment, an alias...).
+func yamlNeedsQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	if strings.ContainsAny(s[:1], "[]{}&*!|>'\"%@`#,?:-") {
+		return true
+	}
+	return strings.Contains(s, ": ") || strings.Contains(s, " #") ||
+		strings.HasSuffix(s, ":") || strings.ContainsAny(s, "\n\r\t")
+}
+
+// unquoteYAMLDouble decodes the escapes YAML shares with JSON. Anything
+// more exotic (\x, \N, line folding) returns ok=false.
+func unquoteYAMLDouble(s string) (string, bool) {
+	var value string
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// verifyYAML checks the structure survived: every line outside a block scalar
+// kept its indentation and sequence markers, in the same order. Scalars are
+// only ever rewritten in place (and quoted when needed), so this catches a
+// value that spilled onto new lines or turned into a nested item. A block
+// scalar body may change length (a key redacted to one line), but a line of it
+// that lost its indentation would end the block and show up here.
+func verifyYAML(original, out string) error {
+	origShapes, outShapes := yamlShapes(original), yamlShapes(out)
+	for i := range origShapes {
+		if i >= len(outShapes) || origShapes[i].shape != outShapes[i].shape {
+			return fmt.Errorf("line %d changed structure", origShapes[i].line)
+		}
+	}
+	if len(outShapes) > len(origShapes) {
+		return fmt.Errorf("line %d is new structure", outShapes[len(origShapes)].line)
+	}
+	return nil
+}
+
+// yamlLineShape is the structure of one line outside a block scalar.
+type yamlLineShape struct {
+	line  int
+	shape string
+}
+
+// yamlShapes returns the shape of each line that isn't part of a block scalar
+// body, found the same way transformYAML finds them.
+func yamlShapes(content string) []yamlLineShape {
+	var shapes []yamlLineShape
+	blockIndent := -1
+	for i, line := range strings.Split(content, "\n") {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if blockIndent >= 0 {
+			if strings.TrimSpace(line) == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if m := yamlKeyLine.FindStringSubmatch(line); m != nil {
+			if yamlBlockHeader.MatchString(strings.TrimSpace(m[5])) {
+				blockIndent = indent
+			}
+		} else if m := yamlSeqLine.FindStringSubmatch(line); m != nil && yamlBlockHeader.MatchString(strings.TrimSpace(m[2])) {
+			blockIndent = indent
+		}
+		shapes = append(shapes, yamlLineShape{i + 1, yamlShape(line)})
+	}
+	return shapes
+}
+
+func yamlShape(line string) string {
+	if m := yamlSeqLine.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// ----------------------------------------------------------------------------
+// XML
+// ----------------------------------------------------------------------------
+
+// Quoted attribute value inside a start tag
+var xmlAttrValue = regexp.MustCompile(`("[^"]*"|'[^']*')`)
+
+// transformXML walks the raw text so untouched markup stays byte-identical.
+// Text content and attribute values are unescaped, transformed and re-escaped;
+// comments and CDATA are transformed as raw text. Element and attribute names
+// are left alone.
+func transformXML(content string, fn func(string) string) (string, bool) {
+	if verifyXML("", content) != nil {
+		return "", false
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(content); {
+		lt := strings.IndexByte(content[i:], '<')
+		if lt < 0 {
+			out.WriteString(xmlText(content[i:], fn))
+			break
+		}
+		out.WriteString(xmlText(content[i:i+lt], fn))
+		i += lt
+
+		rest := content[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest, "-->")
+			out.WriteString("<!--" + xmlComment(fn(rest[4:end])) + "-->")
+			i += end + 3
+		case strings.HasPrefix(rest, "<![CDATA["):
+			end := strings.Index(rest, "]]>")
+			// A "]]>" in the replacement is split across two sections
+			out.WriteString("<![CDATA[" + strings.ReplaceAll(fn(rest[9:end]), "]]>", "]]]]><![CDATA[>") + "]]>")
+			i += end + 3
+		case strings.HasPrefix(rest, "<?") || strings.HasPrefix(rest, "<!"):
+			end := strings.IndexByte(rest, '>')
+			out.WriteString(rest[:end+1])
+			i += end + 1
+		default:
+			end := xmlTagEnd(rest)
+			out.WriteString(xmlAttrValue.ReplaceAllStringFunc(rest[:end+1], func(quoted string) string {
+				q := quoted[:1]
+				value, ok := xmlUnescape(quoted[1 : len(quoted)-1])
+				if !ok {
+					return quoted
+				}
+				replaced := fn(value)
+				if replaced == value {
+					return quoted
+				}
+				return q + xmlEscape(replaced, q[0]) + q
+			}))
+			i += end + 1
+		}
+	}
+	return out.String(), true
+}
+
+// xmlComment makes text safe inside <!-- -->, which can't hold "--" or end
+// with "-": a hyphen that would start one gets a space after it. Only a
+// replacement can bring those in, since the original comment parsed.
+func xmlComment(text string) string {
+	for strings.Contains(text, "--") {
+		text = strings.ReplaceAll(text, "--", "- -")
+	}
+	if strings.HasSuffix(text, "-") {
+		text += " "
+	}
+	return text
+}
+
+// xmlTagEnd finds the '>' that closes a tag, skipping any inside quoted attributes.
+func xmlTagEnd(tag string) int {
+	var quote byte
+	for i := 1; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return len(tag) - 1
+}
+
+func xmlText(raw string, fn func(string) string) string {
+	if strings.TrimSpace(raw) == "" {
+		return raw
+	}
+	value, ok := xmlUnescape(raw)
+	if !ok {
+		return fn(raw) // Unknown entity - transform raw text, don't re-escape it
+	}
+	replaced := fn(value)
+	if replaced == value {
+		return raw
+	}
+	return xmlEscape(replaced, 0)
+}
+
+// xmlUnescape decodes entities and character references using the same rules
+// as verifyXML.
+func xmlUnescape(s string) (string, bool) {
+	if !strings.Contains(s, "&") {
+		return s, true
+	}
+	dec := xml.NewDecoder(strings.NewReader("<v>" + s + "</v>"))
+	dec.Entity = xml.HTMLEntity
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return text.String(), true
+		}
+		if err != nil {
+			return "", false
+		}
+		if data, ok := tok.(xml.CharData); ok {
+			text.Write(data)
+		}
+	}
+}
+
+// xmlEscape escapes what's required for text (quote=0) or a quoted attribute value.
+func xmlEscape(s string, quote byte) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, ">", "&gt;")
+	switch quote {
+	case '"':
+		s = strings.ReplaceAll(s, `"`, "&quot;")
+	case '\'':
+		s = strings.ReplaceAll(s, "'", "&apos;")
+	}
+	return s
+}
+
+func verifyXML(_, out string) error {
+	dec := xml.NewDecoder(strings.NewReader(out))
+	dec.Strict = true
+	dec.Entity = xml.HTMLEntity // Tolerate &nbsp; and friends in config files
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// INI
+// ----------------------------------------------------------------------------
+
+var (
+	iniSection = regexp.MustCompile(`^(\s*\[)([^\]]*)(\].*)$`)
+	iniKey     = regexp.MustCompile(`^(\s*)([^=:;#\s][^=:]*?)(\s*[=:]\s*)(.*)$`)
+	iniComment = regexp.MustCompile(`^\s*[;#]`)
+)
+
+// transformINI handles [section], key = value / key: value and ; or # comments.
+// Quoted values keep their quotes. Other lines get fn applied as raw text.
+func transformINI(content string, fn func(string) string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		switch {
+		case iniComment.MatchString(line):
+			lines[i] = fn(line)
+		case iniSection.MatchString(line):
+			m := iniSection.FindStringSubmatch(line)
+			lines[i] = m[1] + fn(m[2]) + m[3]
+		case iniKey.MatchString(line):
+			m := iniKey.FindStringSubmatch(line)
+			lines[i] = m[1] + fn(m[2]) + m[3] + iniValue(m[4], fn)
+		default:
+			lines[i] = fn(line)
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+func iniValue(v string, fn func(string) string) string {
+	end := strings.TrimRight(v, " \t\r")
+	trailing := v[len(end):]
+	if len(end) >= 2 && (end[0] == '"' || end[0] == '\'') && end[len(end)-1] == end[0] {
+		return end[:1] + fn(end[1:len(end)-1]) + end[:1] + trailing
+	}
+	return fn(end) + trailing
+}
+
+// verifyINI checks every line kept its kind (comment, section, key, other).
+func verifyINI(original, out string) error {
+	origLines := strings.Split(original, "\n")
+	outLines := strings.Split(out, "\n")
+	if len(origLines) != len(outLines) {
+		return fmt.Errorf("line count changed from %d to %d", len(origLines), len(outLines))
+	}
+	for i := range origLines {
+		if iniKind(origLines[i]) != iniKind(outLines[i]) {
+			return fmt.Errorf("line %d changed structure", i+1)
+		}
+	}
+	return nil
+}
+
+func iniKind(line string) int {
+	switch {
+	case iniComment.MatchString(line):
+		return 1
+	case iniSection.MatchString(line):
+		return 2
+	case iniKey.MatchString(line):
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+// glob.go - Path globs for config fields that name files rather than regexes.
+// Like PowerShell's -like, plus ** for "any number of directories".
+package internal
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob reports whether relPath (relative to the project) matches pattern.
+//   - "*" and "?" match within one path segment, "**" matches any number of segments
+//   - A pattern without "/" matches the name at any depth ("*.png"), like .gitignore;
+//     a leading "/" anchors it to the project root ("/logo.png")
+//   - A pattern ending in "/" matches everything under that directory ("assets/")
+//
+// Both sides are compared with forward slashes, case-insensitively (Windows paths).
+func MatchGlob(pattern, relPath string) bool {
+	pattern = strings.ToLower(strings.ReplaceAll(pattern, "\\", "/"))
+	relPath = strings.ToLower(strings.ReplaceAll(relPath, "\\", "/"))
+	relPath = strings.TrimPrefix(relPath, "./")
+
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if !strings.Contains(strings.TrimSuffix(pattern, "/**"), "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// ** can swallow zero or more segments - try each split point
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// globError reports a pattern MatchGlob can never match (an unclosed [).
+func globError(pattern string) error {
+	for _, segment := range strings.Split(strings.ReplaceAll(pattern, "\\", "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchAnyGlob reports whether relPath matches any of the patterns.
+func MatchAnyGlob(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
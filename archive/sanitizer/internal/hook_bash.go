@@ -1,6 +1,7 @@
 // hook_bash.go - PreToolUse hook for Bash commands.
-// Routes commands into three categories:
+// Routes commands into categories:
 // - BLOCK: Commands accessing sanitizer config or unsanitized directory (denied)
+// - ROUTED: The first bashRoutes entry matching the command picks the action
 // - UNSANITIZED: PowerShell commands (wrapped to run in unsanitized directory)
 // - SANITIZED: Everything else (runs as-is in working tree with sanitized values)
 package internal
@@ -8,21 +9,48 @@ package internal
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 )
 
-// Commands that need real values - run UNSANITIZED
-// PowerShell scripts typically interact with real infrastructure
-var unsanitizedCmdPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`(?i)^\s*powershell`), // powershell.exe
-	regexp.MustCompile(`(?i)^\s*pwsh`),       // pwsh (PS Core)
-	regexp.MustCompile(`(?i)\.ps1(\s|$|")`),  // *.ps1 scripts
-	regexp.MustCompile(`^\s*&\s`),            // & (call operator)
+// Route actions: what hook-bash does with a command a BashRoute matches.
+const (
+	RouteDeny        = "deny"                             // Block the command
+	RouteAsk         = "ask"                              // Let the person decide
+	RouteUnsanitized = "run-unsanitized"                  // Wrap in "sanitizer exec": real values, output sanitized
+	RouteSanitized   = "run-sanitized"                    // Run as-is in the working tree
+	RouteFiltered    = "run-sanitized-with-output-filter" // Run as-is, output piped through sanitize-ips
+)
+
+// BashRoute is one entry of "bashRoutes": commands matching a regex go to an
+// action. Like a switch -Regex block, the first matching entry wins.
+type BashRoute struct {
+	Match  string `json:"match"`  // Regex against the command as Claude wrote it
+	Action string `json:"action"` // One of the Route* actions
+	// Shell runs run-unsanitized commands under this interpreter (pwsh,
+	// powershell, bash, sh, cmd or a path) instead of the platform default.
+	Shell string `json:"shell,omitempty"`
+	// Reason is shown to Claude (deny) or the person (ask).
+	Reason string `json:"reason,omitempty"`
+}
+
+// defaultBashRoutes come after bashRoutes: PowerShell scripts typically
+// interact with real infrastructure, so they run UNSANITIZED. A bashRoutes
+// entry matching the same commands overrides them.
+var defaultBashRoutes = []BashRoute{
+	{Match: `(?i)^\s*powershell`, Action: RouteUnsanitized}, // powershell.exe
+	{Match: `(?i)^\s*pwsh`, Action: RouteUnsanitized},       // pwsh (PS Core)
+	{Match: `(?i)\.ps1(\s|$|")`, Action: RouteUnsanitized},  // *.ps1 scripts
+	{Match: `^\s*&\s`, Action: RouteUnsanitized},            // & (call operator)
 }
 
+// Sanitizer subcommands that print real values. Run by a person, never by Claude.
+var sanitizerAdminCmd = regexp.MustCompile(`(?i)sanitizer(\.exe)?['"]?\s+(mappings|config)\b`)
+
+// Commands naming the store passphrase ($SANITIZER_PASSPHRASE, $env:...).
+// Only the obvious way in: env and printenv still list it (see PassphraseEnv).
+var passphraseRef = regexp.MustCompile(`(?i)\b` + PassphraseEnv + `\b`)
+
 // HookBash processes Bash tool invocations before execution.
 // Claude Code sends hook input as JSON on stdin.
 // Returns JSON response or nil (nil = allow command as-is).
@@ -37,7 +65,7 @@ func HookBash(input []byte) ([]byte, error) {
 	}
 
 	if err := json.Unmarshal(input, &hookData); err != nil {
-		return nil, nil // Parse error = allow (fail open)
+		return nil, hookInputError("hook input", err) // See failure.go
 	}
 
 	// Only handle PreToolUse events
@@ -53,7 +81,7 @@ func HookBash(input []byte) ([]byte, error) {
 	// Load config for blocked paths
 	cfg, err := LoadConfig()
 	if err != nil {
-		return nil, nil // Config error = allow (fail open)
+		return nil, configLoadError(err)
 	}
 
 	// Normalize path separators for consistent matching
@@ -66,39 +94,91 @@ func HookBash(input []byte) ([]byte, error) {
 		}
 	}
 
-	// Check if command should run UNSANITIZED (with real values)
-	isUnsanitized := false
-	for _, pattern := range unsanitizedCmdPatterns {
-		if pattern.MatchString(command) {
-			isUnsanitized = true
-			break
-		}
+	if sanitizerAdminCmd.MatchString(normalizedCmd) {
+		return DenyResponse("Blocked: sanitizer mappings and config commands show real values")
+	}
+	if passphraseRef.MatchString(normalizedCmd) {
+		return DenyResponse("Blocked: " + PassphraseEnv + " unlocks the mapping store")
 	}
 
-	// SANITIZED (default): Let command run as-is in working tree
-	if !isUnsanitized {
+	route, ok := cfg.RouteFor(command)
+	if !ok {
+		// SANITIZED (default): Let command run as-is in working tree
 		return nil, nil
 	}
 
-	// UNSANITIZED: Wrap command to run through sanitizer exec.
-	// This syncs to unsanitized directory, runs command with real values,
-	// then sanitizes the output before returning to Claude.
-	sanitizerExe := filepath.Join(os.Getenv("USERPROFILE"), ".claude", "sanitizer", "sanitizer.exe")
+	switch route.Action {
+	case RouteSanitized:
+		return nil, nil
+	case RouteAsk:
+		return askResponse(routeReason(route, "Confirm: command matches bashRoutes"))
+	case RouteUnsanitized:
+		// UNSANITIZED: Wrap command to run through sanitizer exec.
+		// This syncs to unsanitized directory, runs command with real values,
+		// then sanitizes the output before returning to Claude.
+		// SanitizerExe is the running binary, wherever it's installed (see platform.go)
+		exe := quoteBash(SanitizerExe())
+		if route.Shell != "" {
+			return allowWithUpdatedCommand(fmt.Sprintf(`%s exec --shell %s %s`, exe, quoteBash(route.Shell), quoteBash(command)))
+		}
+		return allowWithUpdatedCommand(fmt.Sprintf(`%s exec %s`, exe, quoteBash(command)))
+	case RouteFiltered:
+		// Runs in the working tree as usual, but what it prints (kubectl get,
+		// ssh) comes from real infrastructure: sanitize it on the way back.
+		// pipefail keeps the command's exit code; the outer subshell keeps
+		// the setting from leaking into Claude's shell.
+		return allowWithUpdatedCommand(fmt.Sprintf("(set -o pipefail; (\n%s\n) 2>&1 | %s sanitize-ips)", command, quoteBash(SanitizerExe())))
+	default:
+		// RouteDeny, and unknown actions: fail closed (validate reports them)
+		return DenyResponse(routeReason(route, "Blocked by bashRoutes"))
+	}
+}
 
-	// Escape single quotes for bash: ' becomes '\'' (end quote, escaped quote, start quote)
-	escapedCmd := strings.ReplaceAll(command, "'", `'\''`)
+// RouteFor returns the first bashRoutes entry, then built-in route, whose
+// regex matches the command. Routes whose regex doesn't compile are skipped.
+func (c *Config) RouteFor(command string) (BashRoute, bool) {
+	for _, routes := range [][]BashRoute{c.BashRoutes, defaultBashRoutes} {
+		for _, r := range routes {
+			re, err := compileCached(r.Match)
+			if err != nil || r.Match == "" {
+				continue
+			}
+			if re.MatchString(command) {
+				return r, true
+			}
+		}
+	}
+	return BashRoute{}, false
+}
 
-	wrappedCommand := fmt.Sprintf(`'%s' exec '%s'`, sanitizerExe, escapedCmd)
+func routeReason(r BashRoute, fallback string) string {
+	if r.Reason != "" {
+		return r.Reason
+	}
+	return fallback
+}
 
-	return allowWithUpdatedCommand(wrappedCommand)
+// quoteBash single-quotes a word for bash. A quote inside becomes end quote,
+// escaped quote, start quote.
+func quoteBash(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 // DenyResponse returns JSON that tells Claude Code to block the tool call.
 func DenyResponse(reason string) ([]byte, error) {
+	return decisionResponse("deny", reason)
+}
+
+// askResponse returns JSON that asks the person to approve the tool call.
+func askResponse(reason string) ([]byte, error) {
+	return decisionResponse("ask", reason)
+}
+
+func decisionResponse(decision, reason string) ([]byte, error) {
 	return json.Marshal(map[string]any{
 		"hookSpecificOutput": map[string]any{
 			"hookEventName":      "PreToolUse",
-			"permissionDecision": "deny",
+			"permissionDecision": decision,
 			"reason":             reason,
 		},
 	})
@@ -1,16 +1,19 @@
-// hook_fileaccess.go - PreToolUse hook for Read, Edit, Write tools.
+// hook_fileaccess.go - PreToolUse hook for Read, Edit, Write, NotebookEdit tools.
 // Blocks access to sanitizer internals and ensures files are sanitized before Claude sees them.
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// HookFileAccess processes Read/Edit/Write tool invocations.
+// HookFileAccess processes Read/Edit/Write/NotebookEdit tool invocations.
 // - Blocks access to sensitive paths (configured in blockedPaths)
 // - Sanitizes file content on read/edit (in-place modification)
 // - Sanitizes content before write (modifies tool input)
@@ -19,19 +22,24 @@ func HookFileAccess(input []byte) ([]byte, error) {
 		HookEventName string `json:"hook_event_name"`
 		ToolName      string `json:"tool_name"`
 		ToolInput     struct {
-			FilePath string `json:"file_path"`
-			Content  string `json:"content"` // Only for Write tool
+			FilePath     string `json:"file_path"`
+			Content      string `json:"content"`       // Only for Write tool
+			NotebookPath string `json:"notebook_path"` // Only for NotebookEdit tool
+			NewSource    string `json:"new_source"`    // Only for NotebookEdit tool
 		} `json:"tool_input"`
 	}
 
 	if err := json.Unmarshal(input, &hookData); err != nil {
-		return nil, nil
+		return nil, hookInputError("hook input", err) // See failure.go
 	}
 
 	if hookData.HookEventName != "PreToolUse" {
 		return nil, nil
 	}
 
+	if hookData.ToolInput.FilePath == "" {
+		hookData.ToolInput.FilePath = hookData.ToolInput.NotebookPath
+	}
 	if hookData.ToolInput.FilePath == "" {
 		return nil, nil
 	}
@@ -39,7 +47,7 @@ func HookFileAccess(input []byte) ([]byte, error) {
 	// Load config for blocked paths
 	cfg, err := LoadConfig()
 	if err != nil {
-		return nil, nil // Config error = allow (fail open)
+		return nil, configLoadError(err)
 	}
 
 	// Normalize path separators for consistent matching
@@ -57,8 +65,21 @@ func HookFileAccess(input []byte) ([]byte, error) {
 		return sanitizeWriteContent(hookData.ToolInput.FilePath, hookData.ToolInput.Content)
 	}
 
-	// Read/Edit: sanitize file on disk before Claude reads it
-	SanitizeSingleFile(hookData.ToolInput.FilePath)
+	// Read/Edit/NotebookEdit: sanitize file on disk before Claude reads it
+	if err := SanitizeSingleFile(hookData.ToolInput.FilePath); err != nil {
+		if errors.Is(err, ErrMappingsNotSaved) {
+			return nil, err // The file is still unsanitized: always denied (see HookFailure)
+		}
+		if hookData.ToolName == "Read" && cfg.DenyUnprocessedReads && !cfg.isReadDenyException(hookData.ToolInput.FilePath) {
+			return DenyResponse("Read blocked: " + err.Error() +
+				". The file could not be verified as sanitized; add it to readDenyExceptions if it is safe to read as-is")
+		}
+	}
+
+	// NotebookEdit: the new cell source is written into the notebook as-is
+	if hookData.ToolName == "NotebookEdit" && hookData.ToolInput.NewSource != "" {
+		return sanitizeNotebookEdit(input, hookData.ToolInput.FilePath, hookData.ToolInput.NewSource)
+	}
 
 	return nil, nil
 }
@@ -68,19 +89,18 @@ func HookFileAccess(input []byte) ([]byte, error) {
 func sanitizeWriteContent(filePath, content string) ([]byte, error) {
 	cfg, err := LoadConfig()
 	if err != nil {
-		return nil, nil
+		return nil, configLoadError(err)
 	}
 
 	// Discover any new sensitive values in the content Claude is writing
-	discovered := DiscoverSensitiveValues(content, cfg)
-	autoMappings := cfg.MergeAutoMappings(discovered)
-
-	if len(autoMappings) > len(cfg.MappingsAuto) {
-		SaveAutoMappings(autoMappings)
+	discovered := DiscoverFileValues(content, cfg, filePath)
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if err != nil {
+		return nil, err
 	}
 
 	allMappings := cfg.BuildAllMappings(autoMappings)
-	sanitized := SanitizeText(content, allMappings)
+	sanitized := TransformContent(filePath, content, cfg.FileSanitizer(filePath, allMappings))
 
 	// No changes needed
 	if sanitized == content {
@@ -100,16 +120,60 @@ func sanitizeWriteContent(filePath, content string) ([]byte, error) {
 	})
 }
 
+// sanitizeNotebookEdit sanitizes the new_source of a NotebookEdit call. The
+// notebook itself stays valid because the tool does the JSON encoding; only
+// the cell text changes. Other tool_input fields are passed through unchanged.
+// The notebook's pathRules apply; sightings are recorded against the tool.
+func sanitizeNotebookEdit(input []byte, notebookPath, newSource string) ([]byte, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, configLoadError(err)
+	}
+
+	discovered := discover(newSource, cfg, "NotebookEdit", cfg.DetectorsFor(ProjectRelative(notebookPath)))
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if err != nil {
+		return nil, err
+	}
+
+	sanitized := cfg.FileSanitizer(notebookPath, cfg.BuildAllMappings(autoMappings))(newSource)
+	if sanitized == newSource {
+		return nil, nil
+	}
+
+	// updatedInput replaces the whole tool input, so start from the original
+	var raw struct {
+		ToolInput map[string]any `json:"tool_input"`
+	}
+	if err := json.Unmarshal(input, &raw); err != nil {
+		return nil, hookInputError("hook input", err)
+	}
+	raw.ToolInput["new_source"] = sanitized
+
+	return json.Marshal(map[string]any{
+		"hookSpecificOutput": map[string]any{
+			"hookEventName":      "PreToolUse",
+			"permissionDecision": "allow",
+			"updatedInput":       raw.ToolInput,
+		},
+	})
+}
+
 // SanitizeSingleFile sanitizes a file in-place before Claude reads it.
 // Called on every Read/Edit to catch files that weren't sanitized at session start
 // (new files, modified files, files outside initial walk).
 //
 // Idempotent: if content is already sanitized, file is unchanged.
 // Also saves original content to unsanitized directory for later restoration.
-func SanitizeSingleFile(filePath string) {
+//
+// Returns an error if an in-project file could not be verified as sanitized
+// (skipped, unreadable, an archive that couldn't be rebuilt, or one with
+// members left as they were). Files outside
+// the project, missing files and empty files return nil - there's nothing to hide.
+func SanitizeSingleFile(filePath string) error {
 	projectPath, err := os.Getwd()
 	if err != nil {
-		return
+		return err
 	}
 
 	filePath = filepath.Clean(filePath)
@@ -118,60 +182,100 @@ func SanitizeSingleFile(filePath string) {
 	// Only process files within the project directory
 	// Case-insensitive comparison for Windows (C:\Foo vs c:\foo)
 	if !strings.HasPrefix(strings.ToLower(filePath), strings.ToLower(projectPath)) {
-		return
+		return nil
 	}
 
 	cfg, err := LoadConfig()
 	if err != nil {
-		return
+		return err
 	}
 
-	info, err := os.Stat(filePath)
+	// Lstat so a symlink is reported as one (and its target outside the project isn't rewritten)
+	info, err := os.Lstat(filePath)
 	if err != nil {
-		return
+		return nil // Missing file - the tool call fails on its own
 	}
-
-	if !ShouldProcessFile(filePath, info, projectPath, cfg.SkipPaths) {
-		return
+	if info.IsDir() || info.Size() == 0 {
+		return nil
 	}
 
-	// Calculate paths for unsanitized backup
-	relPath, _ := filepath.Rel(projectPath, filePath)
-	projectName := filepath.Base(projectPath)
-	unsanitizedPath := cfg.ExpandUnsanitizedPath(projectName)
-	unsanitizedFilePath := filepath.Join(unsanitizedPath, relPath)
+	if reason := readSkipReason(filePath, info, projectPath, cfg); reason != "" {
+		return fmt.Errorf("%s not sanitized (%s)", filepath.Base(filePath), reason)
+	}
 
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return
+		return err
 	}
-	currentContent := string(content)
+	// Rich notebook outputs are stripped/redacted first so they are never scanned
+	input := ApplyNotebookOutputs(filePath, content, cfg.NotebookOutputs)
+	// What a container keeps as it is still gets reported once the rest is sanitized
+	unprocessed := UnprocessedMember(filePath, input)
 
 	// Discover new sensitive values and merge with existing
-	discovered := DiscoverSensitiveValues(currentContent, cfg)
-	autoMappings := cfg.MergeAutoMappings(discovered)
+	discovered := DiscoverFileValues(CollectText(filePath, input), cfg, filePath)
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if err != nil {
+		return err
+	}
 
-	if len(autoMappings) > len(cfg.MappingsAuto) {
-		SaveAutoMappings(autoMappings)
+	// Decodes UTF-16, opens archives, format-aware for JSON/YAML/XML/INI
+	sanitized, err := TransformBytes(filePath, input, cfg.FileSanitizer(filePath, cfg.BuildAllMappings(autoMappings)))
+	if err != nil {
+		log.Printf("sanitizer: %v", err)
+		return err
 	}
 
-	sanitized := SanitizeText(currentContent, cfg.BuildAllMappings(autoMappings))
+	// Already sanitized (or no sensitive values) - nothing to write
+	if !bytes.Equal(sanitized, content) {
+		// Write sanitized content to working tree (Claude sees this)
+		if err := os.WriteFile(filePath, sanitized, info.Mode()); err != nil {
+			log.Printf("sanitizer: failed to write %s: %v", filePath, err)
+			return err
+		}
 
-	// Already sanitized (or no sensitive values) - nothing to do
-	if sanitized == currentContent {
-		return
+		// Save original (unsanitized) content for later restoration
+		saveOriginal(cfg, projectPath, filePath, content, info.Mode())
 	}
 
-	// Write sanitized content to working tree (Claude sees this)
-	if err := os.WriteFile(filePath, []byte(sanitized), info.Mode()); err != nil {
-		log.Printf("sanitizer: failed to write %s: %v", filePath, err)
+	if unprocessed != "" {
+		return fmt.Errorf("%s not fully sanitized (%s)", filepath.Base(filePath), unprocessed)
+	}
+	return nil
+}
+
+// isReadDenyException reports whether filePath matches readDenyExceptions.
+// Globs are relative to the project directory.
+func (c *Config) isReadDenyException(filePath string) bool {
+	projectPath, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	relPath, err := filepath.Rel(projectPath, filepath.Clean(filePath))
+	if err != nil {
+		return false
+	}
+	return MatchAnyGlob(c.ReadDenyExceptions, relPath)
+}
+
+// saveOriginal writes a file's original (real-valued) content to the same
+// relative path in the project's unsanitized directory.
+func saveOriginal(cfg *Config, projectPath, filePath string, content []byte, mode os.FileMode) {
+	relPath, _ := filepath.Rel(projectPath, filePath)
+	unsanitizedPath := cfg.ExpandUnsanitizedPath(projectPath)
+	unsanitizedFilePath := filepath.Join(unsanitizedPath, relPath)
+
+	// content may carry tokens from an earlier pass; the copy has their values
+	content, err := cfg.restoreTokens(filePath, unsanitizedFilePath, content)
+	if err != nil {
+		log.Printf("sanitizer: %v", err)
+		return
 	}
 
-	// Save original (unsanitized) content for later restoration
 	if err := os.MkdirAll(filepath.Dir(unsanitizedFilePath), 0755); err != nil {
 		log.Printf("sanitizer: failed to create dir %s: %v", filepath.Dir(unsanitizedFilePath), err)
 	}
-	if err := os.WriteFile(unsanitizedFilePath, content, info.Mode()); err != nil {
+	if err := os.WriteFile(unsanitizedFilePath, content, mode); err != nil {
 		log.Printf("sanitizer: failed to write backup %s: %v", unsanitizedFilePath, err)
 	}
 }
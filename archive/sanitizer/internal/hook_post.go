@@ -1,6 +1,9 @@
 // hook_post.go - PostToolUse hook for sanitizing tool output.
 // Catches sensitive values in Grep/Glob output that might have been missed
-// (new values added after session start, files modified outside Claude, etc.)
+// (new values added after session start, files modified outside Claude, etc.),
+// and in the stdout/stderr of Bash commands that ran SANITIZED: cat /etc/hosts,
+// ipconfig or env print real values no file sanitization ever saw. The safety
+// net behind the routing in hook_bash.go.
 package internal
 
 import (
@@ -13,7 +16,7 @@ import (
 // Configure in settings.json:
 //
 //	"PostToolUse": [{
-//	  "matcher": "Grep|Glob",
+//	  "matcher": "Grep|Glob|Bash",
 //	  "hooks": [{ "type": "command", "command": "sanitizer.exe hook-post" }]
 //	}]
 func HookPostToolUse(input []byte) ([]byte, error) {
@@ -21,37 +24,42 @@ func HookPostToolUse(input []byte) ([]byte, error) {
 		HookEventName string `json:"hook_event_name"`
 		ToolName      string `json:"tool_name"`
 		ToolOutput    string `json:"tool_output"`
+		// Bash sends an object: stdout, stderr, interrupted, ...
+		ToolResponse json.RawMessage `json:"tool_response"`
 	}
 
 	if err := json.Unmarshal(input, &hookData); err != nil {
-		return nil, nil
+		return nil, hookInputError("hook input", err) // See failure.go
 	}
 
 	if hookData.HookEventName != "PostToolUse" {
 		return nil, nil
 	}
 
+	if hookData.ToolName == "Bash" && len(hookData.ToolResponse) > 0 {
+		return sanitizeBashResponse(hookData.ToolResponse)
+	}
 	if hookData.ToolOutput == "" {
 		return nil, nil
 	}
 
 	cfg, err := LoadConfig()
 	if err != nil {
-		return nil, nil
+		return nil, configLoadError(err)
 	}
 
 	// Discover any new sensitive values in the output
-	discovered := DiscoverSensitiveValues(hookData.ToolOutput, cfg)
-	autoMappings := cfg.MergeAutoMappings(discovered)
-
-	// Save new mappings if we found any
-	if len(autoMappings) > len(cfg.MappingsAuto) {
-		SaveAutoMappings(autoMappings)
+	discovered := DiscoverSensitiveValues(hookData.ToolOutput, cfg, hookData.ToolName)
+	// Save new mappings and sightings if we found any. The saved mappings win
+	// over ours if another hook stored the same value first.
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if err != nil {
+		return nil, err
 	}
 
 	// Sanitize the output
 	allMappings := cfg.BuildAllMappings(autoMappings)
-	sanitized := SanitizeText(hookData.ToolOutput, allMappings)
+	sanitized := cfg.Sanitize(hookData.ToolOutput, allMappings)
 
 	// No changes needed
 	if sanitized == hookData.ToolOutput {
@@ -59,10 +67,56 @@ func HookPostToolUse(input []byte) ([]byte, error) {
 	}
 
 	// Return modified output
+	return updatedOutput(sanitized)
+}
+
+// sanitizeBashResponse sanitizes the stdout and stderr of a Bash
+// tool_response. Other fields (interrupted, ...) are passed through unchanged.
+func sanitizeBashResponse(raw json.RawMessage) ([]byte, error) {
+	var response map[string]any
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, hookInputError("tool_response", err)
+	}
+	stdout, _ := response["stdout"].(string)
+	stderr, _ := response["stderr"].(string)
+	if stdout == "" && stderr == "" {
+		return nil, nil
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, configLoadError(err)
+	}
+
+	// One discovery pass over both streams: two would each issue new
+	// pseudonyms without seeing the other's
+	discovered := DiscoverSensitiveValues(stdout+"\n"+stderr, cfg, "Bash")
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(discovered))
+	if err != nil {
+		return nil, err
+	}
+	allMappings := cfg.BuildAllMappings(autoMappings)
+
+	changed := false
+	for field, text := range map[string]string{"stdout": stdout, "stderr": stderr} {
+		if sanitized := cfg.Sanitize(text, allMappings); sanitized != text {
+			response[field] = sanitized
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return updatedOutput(response)
+}
+
+// updatedOutput returns JSON that replaces the tool's output: a string for
+// Grep/Glob, the whole tool_response object for Bash.
+func updatedOutput(output any) ([]byte, error) {
 	return json.Marshal(map[string]any{
 		"hookSpecificOutput": map[string]any{
 			"hookEventName": "PostToolUse",
-			"updatedOutput": sanitized,
+			"updatedOutput": output,
 		},
 	})
 }
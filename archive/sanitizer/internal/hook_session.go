@@ -4,6 +4,8 @@
 package internal
 
 import (
+	"bytes"
+	"log"
 	"os"
 	"path/filepath"
 )
@@ -26,6 +28,7 @@ func HookSessionStart(input []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	cfg.MigrateUnsanitizedDir(projectPath) // Before saveOriginal writes to the new path
 
 	// Phase 1: Collect all processable files
 	var files []string
@@ -33,7 +36,10 @@ func HookSessionStart(input []byte) ([]byte, error) {
 		if err != nil {
 			return nil // Skip errors, continue walking
 		}
-		if ShouldProcessFile(path, info, projectPath, cfg.SkipPaths) {
+		if info.IsDir() {
+			return skipWalkDir(cfg, projectPath, path)
+		}
+		if ShouldProcessFile(path, info, projectPath, cfg) {
 			files = append(files, path)
 		}
 		return nil
@@ -48,46 +54,64 @@ func HookSessionStart(input []byte) ([]byte, error) {
 		if err != nil {
 			continue
 		}
-		for k, v := range DiscoverSensitiveValues(string(content), cfg) {
+		content = ApplyNotebookOutputs(path, content, cfg.NotebookOutputs)
+		for k, v := range DiscoverFileValues(CollectText(path, content), cfg, path) {
 			if _, exists := allDiscovered[k]; !exists {
 				allDiscovered[k] = v
 			}
 		}
 	}
 
-	// Merge discovered with existing auto mappings and save
-	autoMappings := cfg.MergeAutoMappings(allDiscovered)
-	if len(autoMappings) > len(cfg.MappingsAuto) {
-		SaveAutoMappings(autoMappings)
+	// Merge discovered with existing auto mappings and save. Nothing is
+	// sanitized with mappings that didn't make it to disk
+	autoMappings, err := cfg.SaveAutoMappings(cfg.MergeAutoMappings(allDiscovered))
+	if err != nil {
+		return nil, err
 	}
 
-	// Build complete mapping set (auto + manual)
-	allMappings := cfg.BuildAllMappings(autoMappings)
+	// Build complete mapping set (auto + manual); pathRules pick each file's redactions
+	replace := newReplacer(cfg.BuildAllMappings(autoMappings))
 
 	// Phase 3: Sanitize all files with complete mappings
 	for _, path := range files {
-		content, err := os.ReadFile(path)
+		original, err := os.ReadFile(path)
 		if err != nil {
 			continue
 		}
 
-		original := string(content)
-		sanitized := SanitizeText(original, allMappings)
+		sanitized, err := TransformBytes(path, ApplyNotebookOutputs(path, original, cfg.NotebookOutputs), cfg.fileSanitizer(path, replace))
+		if err != nil {
+			log.Printf("sanitizer: %v", err)
+			continue
+		}
 
 		// Only write if content changed
-		if sanitized != original {
+		if !bytes.Equal(sanitized, original) {
 			info, _ := os.Stat(path)
 			mode := os.FileMode(0644)
 			if info != nil {
 				mode = info.Mode()
 			}
-			os.WriteFile(path, []byte(sanitized), mode)
+			os.WriteFile(path, sanitized, mode)
+
+			// Rebuilt archives aren't byte-identical to the original, and
+			// redacted or hashed values have no mapping to reverse: keep the
+			// original next to the other real-valued files, or session stop
+			// would replace it with the tokens (see restore.go)
+			if IsContainer(path) || newTokens(original, sanitized) {
+				saveOriginal(cfg, projectPath, path, original, mode)
+			}
 		}
 	}
 
 	return nil, nil
 }
 
+// newTokens reports whether sanitizing added redaction or hash tokens.
+func newTokens(original, sanitized []byte) bool {
+	return len(irreversibleTokenRegex.FindAll(sanitized, -1)) > len(irreversibleTokenRegex.FindAll(original, -1))
+}
+
 // HookSessionStop runs when Claude Code session ends.
 // Syncs the sanitized working tree to the unsanitized directory,
 // reversing the sanitization so files have real values for deployment.
@@ -102,8 +126,8 @@ func HookSessionStop(input []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	projectName := filepath.Base(projectPath)
-	unsanitizedPath := cfg.ExpandUnsanitizedPath(projectName)
+	cfg.MigrateUnsanitizedDir(projectPath)
+	unsanitizedPath := cfg.ExpandUnsanitizedPath(projectPath)
 
 	if err := os.MkdirAll(unsanitizedPath, 0755); err != nil {
 		return nil, err
@@ -114,11 +138,21 @@ func HookSessionStop(input []byte) ([]byte, error) {
 
 	// Transform function that unsanitizes content
 	transform := func(content string) string {
-		return UnsanitizeText(content, reverseMappings)
+		restored := UnsanitizeText(content, reverseMappings)
+		if restored != content {
+			cfg.recordRestored(content, reverseMappings)
+		}
+		return restored
 	}
 
 	// Sync entire project to unsanitized directory with transformation
-	SyncDir(projectPath, unsanitizedPath, cfg.SkipPaths, transform)
+	SyncDir(projectPath, unsanitizedPath, cfg, transform)
+
+	// Mark restored values as seen, and fold in the session's sightings, so
+	// "mappings gc" keeps them
+	if err := cfg.FlushMappingStore(); err != nil {
+		log.Printf("sanitizer: %v", err) // The sync is done; only lastSeen and hits are behind
+	}
 
 	return nil, nil
 }
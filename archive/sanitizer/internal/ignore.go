@@ -0,0 +1,167 @@
+// ignore.go - .gitignore and .sanitizerignore: files the sanitizer leaves alone.
+// Build outputs, caches and vendored trees would otherwise be rewritten at
+// every session start and copied on every exec. Same syntax and precedence
+// as git, nested files included. Like Get-ChildItem -Exclude, but read from
+// the files a project already has.
+package internal
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// SanitizerIgnoreFile lists paths to leave alone in gitignore syntax. Unlike
+// .gitignore it always applies; its patterns come after .gitignore's in the
+// same directory, so it can also un-ignore ("!dist/config.json").
+const SanitizerIgnoreFile = ".sanitizerignore"
+
+// ignoreCase follows git's default core.ignorecase: on for the case-insensitive
+// filesystems of Windows and macOS.
+var ignoreCase = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// ignorePattern is one line of an ignore file.
+type ignorePattern struct {
+	segments []string // Split on "/"; matched with matchSegments (see glob.go)
+	negate   bool     // "!pattern": un-ignore
+	dirOnly  bool     // "pattern/": directories only
+	anchored bool     // Contains a "/": relative to the file's directory, not any depth
+	source   string   // File it came from, for the skip reason
+}
+
+// ignoreMatcher answers "is this path ignored" for one project, reading the
+// ignore files of each directory the first time a path under it is asked about.
+type ignoreMatcher struct {
+	root      string
+	gitignore bool                       // respectGitignore
+	dirs      map[string][]ignorePattern // Project-relative dir ("" = root) -> its patterns
+}
+
+// ignoreFor returns the matcher for the project at root, reused across calls.
+func (c *Config) ignoreFor(root string) *ignoreMatcher {
+	if c.ignore == nil || c.ignore.root != root {
+		c.ignore = &ignoreMatcher{root: root, gitignore: c.RespectGitignore, dirs: make(map[string][]ignorePattern)}
+	}
+	return c.ignore
+}
+
+// patterns loads (once) the ignore files in a project-relative directory.
+func (m *ignoreMatcher) patterns(dir string) []ignorePattern {
+	if patterns, ok := m.dirs[dir]; ok {
+		return patterns
+	}
+	var patterns []ignorePattern
+	files := []string{SanitizerIgnoreFile}
+	if m.gitignore {
+		files = []string{".gitignore", SanitizerIgnoreFile}
+	}
+	for _, name := range files {
+		patterns = append(patterns, readIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), name), path.Join(dir, name))...)
+	}
+	m.dirs[dir] = patterns
+	return patterns
+}
+
+// reason explains why a project-relative path (forward slashes) is ignored,
+// or returns "". A path inside an ignored directory is ignored whatever later
+// patterns say, as in git: it never looks inside a directory it ignores.
+func (m *ignoreMatcher) reason(relPath string, isDir bool) string {
+	if ignoreCase {
+		relPath = strings.ToLower(relPath)
+	}
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if source := m.match(parts[:i], true); source != "" {
+			return source
+		}
+	}
+	return m.match(parts, isDir)
+}
+
+// match applies every ignore file from the root down to the path's own
+// directory, in order; the last matching pattern decides.
+func (m *ignoreMatcher) match(parts []string, isDir bool) string {
+	ignored := ""
+	for depth := 0; depth < len(parts); depth++ {
+		dir := strings.Join(parts[:depth], "/")
+		rel := parts[depth:]
+		for _, p := range m.patterns(dir) {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			var ok bool
+			if p.anchored {
+				ok = matchSegments(p.segments, rel)
+			} else {
+				ok, _ = path.Match(p.segments[0], rel[len(rel)-1])
+			}
+			if ok {
+				ignored = p.source
+				if p.negate {
+					ignored = ""
+				}
+			}
+		}
+	}
+	return ignored
+}
+
+// readIgnoreFile parses gitignore syntax. A missing file has no patterns.
+func readIgnoreFile(file, source string) []ignorePattern {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseIgnoreLine(scanner.Text()); ok {
+			p.source = source
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parseIgnoreLine reads one line: blank lines and # comments are skipped,
+// "\#" and "\!" escape a leading # or !, trailing spaces are dropped unless
+// escaped with "\".
+func parseIgnoreLine(line string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	var p ignorePattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	if ignoreCase {
+		line = strings.ToLower(line)
+	}
+	p.anchored = strings.Contains(line, "/")
+	p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	if n := len(p.segments); p.anchored && p.segments[n-1] == "**" {
+		// "dir/**" is everything inside dir but not dir itself, so "!dir/keep" still works
+		p.segments = append(p.segments[:n-1], "*", "**")
+	}
+	return p, true
+}
@@ -5,7 +5,9 @@ package internal
 import (
 	"fmt"
 	"math/rand"
+	"net/netip"
 	"regexp"
+	"strings"
 )
 
 // Package-level variables initialized once at startup.
@@ -14,25 +16,102 @@ var (
 	// \b = word boundary to avoid matching "111.139.3.127" inside "111.155.14.109"
 	ipv4Regex = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\b`)
 
-	// IPs that should NOT be sanitized - infrastructure/reserved addresses
-	excludeIPPatterns = []*regexp.Regexp{
-		regexp.MustCompile(`^127\.`),              // loopback (localhost)
-		regexp.MustCompile(`^0\.0\.0\.0$`),        // unspecified/any
-		regexp.MustCompile(`^255\.`),              // subnet masks
-		regexp.MustCompile(`^169\.254\.`),         // link-local (APIPA)
-		regexp.MustCompile(`^2(2[4-9]|3[0-9])\.`), // multicast 224.x-239.x
-		regexp.MustCompile(`^111\.`),              // our sanitized IP range
+	// IPs that should NOT be sanitized - infrastructure/reserved addresses.
+	// ipInclude can bring a more specific range back (see ipFilter).
+	builtinIPExclude = []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/8"),    // loopback (localhost)
+		netip.MustParsePrefix("0.0.0.0/32"),     // unspecified/any
+		netip.MustParsePrefix("255.0.0.0/8"),    // subnet masks
+		netip.MustParsePrefix("169.254.0.0/16"), // link-local (APIPA)
+		netip.MustParsePrefix("224.0.0.0/4"),    // multicast 224.x-239.x
 	}
+
+	// sanitizedIPRange is where NewSanitizedIP draws from. Never sanitized,
+	// whatever the config says: that would replace pseudonyms with pseudonyms.
+	sanitizedIPRange = netip.MustParsePrefix("111.0.0.0/8")
 )
 
-// IsExcludedIP returns true if this IP should NOT be sanitized.
-func IsExcludedIP(ip string) bool {
-	for _, pattern := range excludeIPPatterns {
-		if pattern.MatchString(ip) {
-			return true
+// ipRule is one prefix from ipInclude, ipExclude or builtinIPExclude.
+type ipRule struct {
+	prefix  netip.Prefix
+	include bool
+}
+
+// ipFilter decides which discovered IPs are sanitized. The most specific
+// matching prefix wins, as in a routing table, and exclude wins a tie: with
+// ipInclude 10.0.0.0/8 and ipExclude 10.1.0.0/16, 10.1.2.3 stays readable and
+// 10.2.3.4 is hidden. An IP no rule matches is sanitized unless ipInclude is
+// set, which makes it an allowlist.
+type ipFilter struct {
+	rules     []ipRule
+	allowlist bool
+}
+
+// newIPFilter builds the filter from ipInclude and ipExclude. Entries that don't
+// parse are skipped ("config validate" reports them). The allowlist is only on
+// if every ipInclude entry parsed: one that didn't might have been the range
+// that needed hiding, so a broken allowlist sanitizes everything instead.
+func (c *Config) newIPFilter() *ipFilter {
+	f := &ipFilter{allowlist: len(c.IPInclude) > 0}
+	for _, p := range builtinIPExclude {
+		f.rules = append(f.rules, ipRule{prefix: p})
+	}
+	for _, list := range []struct {
+		entries []string
+		include bool
+	}{{c.IPExclude, false}, {c.IPInclude, true}} {
+		for _, entry := range list.entries {
+			p, err := parseIPPrefix(entry)
+			if err != nil {
+				if list.include {
+					f.allowlist = false
+				}
+				continue
+			}
+			f.rules = append(f.rules, ipRule{prefix: p, include: list.include})
+		}
+	}
+	return f
+}
+
+// hides reports whether ip should be replaced. Anything that isn't an
+// address netip accepts (010.0.0.1) is sanitized: hiding it is the safe side.
+func (f *ipFilter) hides(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return true
+	}
+	if sanitizedIPRange.Contains(addr) {
+		return false
+	}
+	best, sanitize := -1, !f.allowlist
+	for _, r := range f.rules {
+		if !r.prefix.Contains(addr) {
+			continue
 		}
+		if bits := r.prefix.Bits(); bits > best || bits == best && !r.include {
+			best, sanitize = bits, r.include
+		}
+	}
+	return sanitize
+}
+
+// parseIPPrefix reads a CIDR (10.0.0.0/8) or a single address (8.8.8.8 = /32).
+// Host bits are dropped, so 10.1.2.3/8 means 10.0.0.0/8.
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
 	}
-	return false
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
 }
 
 // NewSanitizedIP generates a random fake IP in the 111.x.x.x range.
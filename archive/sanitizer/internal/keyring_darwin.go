@@ -0,0 +1,27 @@
+// keyring_darwin.go - macOS keyring: a generic password in the login Keychain.
+package internal
+
+import (
+	"os/exec"
+)
+
+const (
+	keyringService = "claude-sanitizer"
+	keyringAccount = "mapping-store"
+)
+
+func keyringGet() ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password",
+		"-s", keyringService, "-a", keyringAccount, "-w").Output()
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeyringSecret(string(out))
+}
+
+func keyringSet(key []byte) error {
+	// -U updates an existing item instead of failing. security can't read -w
+	// from stdin without prompting, so the secret is briefly on the command line.
+	return exec.Command("security", "add-generic-password", "-U",
+		"-s", keyringService, "-a", keyringAccount, "-w", encodeKeyringSecret(key)).Run()
+}
@@ -0,0 +1,32 @@
+//go:build !windows && !darwin
+
+// keyring_unix.go - Linux/BSD keyring: the Secret Service (GNOME Keyring,
+// KWallet) through secret-tool from libsecret.
+package internal
+
+import (
+	"os/exec"
+	"strings"
+)
+
+const (
+	keyringService = "claude-sanitizer"
+	keyringAccount = "mapping-store"
+)
+
+func keyringGet() ([]byte, error) {
+	out, err := exec.Command("secret-tool", "lookup",
+		"service", keyringService, "account", keyringAccount).Output()
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeyringSecret(string(out))
+}
+
+func keyringSet(key []byte) error {
+	// secret-tool reads the secret from stdin, keeping it off the command line
+	cmd := exec.Command("secret-tool", "store", "--label=Claude sanitizer mapping store",
+		"service", keyringService, "account", keyringAccount)
+	cmd.Stdin = strings.NewReader(encodeKeyringSecret(key))
+	return cmd.Run()
+}
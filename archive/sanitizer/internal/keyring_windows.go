@@ -0,0 +1,73 @@
+// keyring_windows.go - Windows "keyring": the key encrypted with DPAPI.
+// DPAPI ties the blob to the Windows user's login, so the file is useless
+// if copied to another account or machine. Same protection as Credential
+// Manager, without needing extra modules.
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+const cryptprotectUIForbidden = 0x1
+
+// dataBlob is the Win32 DATA_BLOB struct.
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+func keyringPath() string {
+	return filepath.Join(SanitizerDir(), "store.dpapi.key")
+}
+
+func keyringGet() ([]byte, error) {
+	blob, err := os.ReadFile(keyringPath())
+	if err != nil {
+		return nil, err
+	}
+	secret, err := dpapi(procCryptUnprotectData, blob)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeyringSecret(string(secret))
+}
+
+func keyringSet(key []byte) error {
+	blob, err := dpapi(procCryptProtectData, []byte(encodeKeyringSecret(key)))
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(SanitizerDir(), 0755)
+	return os.WriteFile(keyringPath(), blob, 0600)
+}
+
+// dpapi calls CryptProtectData or CryptUnprotectData (same signature).
+func dpapi(proc *syscall.LazyProc, in []byte) ([]byte, error) {
+	if len(in) == 0 {
+		return nil, syscall.EINVAL
+	}
+	input := dataBlob{cbData: uint32(len(in)), pbData: &in[0]}
+	var output dataBlob
+	r, _, err := proc.Call(
+		uintptr(unsafe.Pointer(&input)),
+		0, 0, 0, 0,
+		cryptprotectUIForbidden,
+		uintptr(unsafe.Pointer(&output)),
+	)
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(output.pbData)))
+	return append([]byte{}, unsafe.Slice(output.pbData, output.cbData)...), nil
+}
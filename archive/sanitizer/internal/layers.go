@@ -0,0 +1,465 @@
+// layers.go - Layered configuration: the global sanitizer.json, files it
+// includes, project-local config, then environment variables, each merged
+// over the one before. Like PowerShell's $PSDefaultParameterValues layered
+// under explicit parameters: the most specific setting wins.
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ProjectConfigFiles are the project-local config files, relative to the
+// project, lowest precedence first. Either or both may exist.
+var ProjectConfigFiles = []string{".sanitizer.json", ".claude/sanitizer.json"}
+
+// maxIncludeDepth bounds include chains (a includes b includes c ...).
+const maxIncludeDepth = 8
+
+// envOverrides are environment variables that override single settings, for
+// paths and modes that differ per machine or per shell.
+var envOverrides = []struct {
+	name   string
+	path   []string // Setting, as keys from the top of sanitizer.json
+	isBool bool     // Parsed as true/false/1/0
+}{
+	{"SANITIZER_UNSANITIZED_PATH", []string{"unsanitizedPath"}, false},
+	{"SANITIZER_MAPPING_SCOPE", []string{"mappingScope"}, false},
+	{"SANITIZER_NOTEBOOK_OUTPUTS", []string{"notebookOutputs"}, false},
+	{"SANITIZER_DENY_UNPROCESSED_READS", []string{"denyUnprocessedReads"}, true},
+	{FailureModeEnv, []string{"failureMode"}, false},
+	{"SANITIZER_ENCRYPTION_KEY", []string{"encryption", "key"}, false},
+	{"SANITIZER_ENCRYPTION_KEY_FILE", []string{"encryption", "keyFile"}, false},
+	{"SANITIZER_SHARED_MAPPINGS", []string{"sharedMappings", "path"}, false},
+	{"SANITIZER_TEAM_KEY_FILE", []string{"sharedMappings", "keyFile"}, false},
+}
+
+// configLayer is one file or environment variable that went into the config.
+type configLayer struct {
+	name    string // As shown in problems: sanitizer.json, .sanitizer.json, an include, or an env var
+	file    string // Full path; "" for an env var
+	data    []byte // File as read (BOM stripped), for line numbers
+	offsets map[string]int64
+	project bool // Project-local file or one of its includes
+	builtin bool // A profile (see profile.go): no file, below every other layer
+
+	rejected []rejectedSetting // Settings a project file may not set, left out of the merge
+}
+
+// rejectedSetting is one setting of a project file that was ignored, and why.
+type rejectedSetting struct {
+	path   string // JSON path in the file
+	reason string
+}
+
+// projectSettings are what a project-local file may set: what to look for and
+// where. A repo is untrusted input, so settings that decide where real values
+// go, how they're protected, what goes unsanitized, or how a failure is
+// handled stay with the user's own files: a cloned repo mustn't point
+// unsanitizedPath into itself, turn encryption off, skip its own files or
+// fail open.
+var projectSettings = map[string]bool{
+	"hostnamePatterns": true,
+	"secretPatterns":   true,
+	"policies":         true,
+	"pathRules":        true, // hostnamePatterns only
+	"blockedPaths":     true,
+	"mappingsManual":   true, // Except identity mappings
+	"bashRoutes":       true, // deny and ask only
+	"profile":          true, // Except minimal
+}
+
+// settingOrigin is where a setting of the merged config was set.
+type settingOrigin struct {
+	layer int    // Index into Config.layers
+	path  string // JSON path in that layer; differs from the merged path after list appends
+}
+
+// listDefaults are the built-in values of lists a project file appends to
+// when the global file doesn't set them. A list in the global file (or one
+// it includes) replaces the default, as it always has.
+var listDefaults = map[string][]string{
+	"blockedPaths": DefaultBlockedPaths,
+}
+
+// configMerge builds the merged raw config and remembers where each setting came from.
+type configMerge struct {
+	layers     []*configLayer
+	origins    map[string]settingOrigin
+	merged     map[string]any
+	projectDir string // Project files may only include files in here
+}
+
+// loadLayers reads every layer and merges them. The global file is migrated
+// (see migrate.go) and the upgrade written back; other files are migrated in
+// memory only, since they may be shared or read-only.
+func (c *Config) loadLayers() (map[string]any, error) {
+	m := &configMerge{origins: make(map[string]settingOrigin), merged: make(map[string]any)}
+
+	if err := m.addFile(c.path, filepath.Base(c.path), false, true, nil); err != nil {
+		return nil, err
+	}
+	if dir, err := os.Getwd(); err == nil {
+		m.projectDir = dir
+		for _, rel := range ProjectConfigFiles {
+			if err := m.addFile(filepath.Join(dir, rel), rel, true, false, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := m.addEnv(); err != nil {
+		return nil, err
+	}
+	m.addProfile()
+
+	c.layers, c.origins = m.layers, m.origins
+	m.merged["version"] = ConfigVersion // Every layer has been migrated
+	return m.merged, nil
+}
+
+// addFile merges a config file, after the files it includes. A missing file
+// is skipped unless it was named by an include (chain holds the includers).
+func (m *configMerge) addFile(path, name string, project, global bool, chain []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && len(chain) == 0 {
+			return nil
+		}
+		if len(chain) > 0 {
+			return fmt.Errorf("%s: include %q: %w", chain[len(chain)-1], name, err)
+		}
+		return err
+	}
+	data = stripBOM(data)
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return jsonError(name, data, err)
+	}
+	if raw == nil {
+		raw = make(map[string]any) // File holds JSON null
+	}
+	// Type errors, located in this file rather than in the merged result
+	if err := json.Unmarshal(data, &Config{}); err != nil {
+		return jsonError(name, data, err)
+	}
+	from, err := migrateConfig(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if global && len(chain) == 0 && from < ConfigVersion && fileWritable(path) {
+		// Write the upgrade back so it happens once. A read-only config still
+		// loads, upgraded in memory each time ("config validate" reports it).
+		err := updateConfigFile(path, func(raw map[string]any) { migrateConfig(raw) })
+		if err != nil {
+			log.Printf("sanitizer: could not save upgraded %s: %v", name, err)
+		}
+	}
+
+	includes, err := includeList(raw["include"])
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	_, includeIsList := raw["include"].([]any)
+	delete(raw, "include")
+	delete(raw, "version")
+	if !global || len(chain) > 0 {
+		// Discovered mappings are per machine: only the global file's legacy copies count
+		delete(raw, "mappingsAuto")
+		delete(raw, "counters")
+	}
+	var rejected []rejectedSetting
+	if project {
+		rejected = filterProjectSettings(raw)
+	}
+
+	// Includes go under the including file, so its own settings win
+	chain = append(chain, name)
+	if len(chain) > maxIncludeDepth {
+		return fmt.Errorf("%s: includes nested more than %d deep", name, maxIncludeDepth)
+	}
+	for i, inc := range includes {
+		incPath := expandHome(inc)
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(filepath.Dir(path), incPath)
+		}
+		if project && !withinDir(m.projectDir, incPath) {
+			incSetting := "include"
+			if includeIsList {
+				incSetting = jsonPath("include", i)
+			}
+			rejected = append(rejected, rejectedSetting{incSetting, "a project file can only include files in the project, include is ignored"})
+			continue
+		}
+		for _, seen := range chain {
+			if seen == inc {
+				return fmt.Errorf("%s: include cycle through %q", name, inc)
+			}
+		}
+		if err := m.addFile(incPath, inc, project, global, chain); err != nil {
+			return err
+		}
+	}
+
+	layer := len(m.layers)
+	m.layers = append(m.layers, &configLayer{name: name, file: path, data: data, offsets: jsonOffsets(data), project: project, rejected: rejected})
+	m.object(m.merged, raw, "", "", layer)
+	return nil
+}
+
+// filterProjectSettings removes what a project file may not set from raw, and
+// returns what it removed.
+func filterProjectSettings(raw map[string]any) []rejectedSetting {
+	var rejected []rejectedSetting
+	for _, key := range sortedKeys(raw) {
+		if !projectSettings[key] {
+			delete(raw, key)
+			rejected = append(rejected, rejectedSetting{key, "not allowed in a project file, setting is ignored; set it in sanitizer.json"})
+		}
+	}
+	if manual, ok := raw["mappingsManual"].(map[string]any); ok {
+		for _, real := range sortedKeys(manual) {
+			if manual[real] == real {
+				// Identity mappings mark false positives; from a repo they'd exempt its secrets
+				delete(manual, real)
+				rejected = append(rejected, rejectedSetting{jsonPath("mappingsManual", real), "identity mapping not allowed in a project file, entry is ignored"})
+			}
+		}
+	}
+	if rules, ok := raw["pathRules"].([]any); ok {
+		for i, r := range rules {
+			rule, _ := r.(map[string]any)
+			for _, key := range []string{"only", "disable", "skip"} {
+				if _, set := rule[key]; set {
+					// Each turns detection off for the rule's paths
+					delete(rule, key)
+					rejected = append(rejected, rejectedSetting{jsonPath("pathRules", i, key), "a project file's pathRules may only add hostnamePatterns, setting is ignored"})
+				}
+			}
+		}
+	}
+	if routes, ok := raw["bashRoutes"].([]any); ok {
+		var bad []rejectedSetting
+		for i, r := range routes {
+			route, _ := r.(map[string]any)
+			if action := route["action"]; action != RouteDeny && action != RouteAsk {
+				bad = append(bad, rejectedSetting{jsonPath("bashRoutes", i), fmt.Sprintf("a project file's routes may only %s or %s, bashRoutes is ignored", RouteDeny, RouteAsk)})
+			}
+		}
+		if len(bad) > 0 {
+			delete(raw, "bashRoutes")
+			rejected = append(rejected, bad...)
+		}
+	}
+	if raw["profile"] == ProfileMinimal {
+		delete(raw, "profile")
+		rejected = append(rejected, rejectedSetting{"profile", "minimal lowers protection and is not allowed in a project file, setting is ignored"})
+	}
+	return rejected
+}
+
+// withinDir reports whether path is dir or inside it, symlinks resolved.
+func withinDir(dir, path string) bool {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// includeList reads the "include" directive: a path or a list of paths.
+func includeList(v any) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		var paths []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("include must be a path or a list of paths")
+			}
+			paths = append(paths, s)
+		}
+		return paths, nil
+	}
+	return nil, fmt.Errorf("include must be a path or a list of paths")
+}
+
+// addEnv merges the environment overrides that are set, one layer each.
+func (m *configMerge) addEnv() error {
+	for _, env := range envOverrides {
+		value, ok := os.LookupEnv(env.name)
+		if !ok {
+			continue
+		}
+		var v any = value
+		if env.isBool {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not true or false", env.name, value)
+			}
+			v = b
+		}
+		raw := map[string]any{}
+		obj := raw
+		for _, key := range env.path[:len(env.path)-1] {
+			next := map[string]any{}
+			obj[key] = next
+			obj = next
+		}
+		obj[env.path[len(env.path)-1]] = v
+
+		layer := len(m.layers)
+		m.layers = append(m.layers, &configLayer{name: env.name})
+		m.object(m.merged, raw, "", "", layer)
+	}
+	return nil
+}
+
+// object merges src into dst: objects key by key, lists appended (items
+// already in the list are skipped), anything else replaced.
+func (m *configMerge) object(dst, src map[string]any, dstPath, srcPath string, layer int) {
+	for _, key := range sortedKeys(src) {
+		value := src[key]
+		dp, sp := dstPath+jsonKey(dstPath == "", key), srcPath+jsonKey(srcPath == "", key)
+		existing, exists := dst[key]
+
+		switch v := value.(type) {
+		case map[string]any:
+			obj, ok := existing.(map[string]any)
+			if !ok {
+				obj = make(map[string]any)
+				dst[key] = obj
+			}
+			m.origins[dp] = settingOrigin{layer, sp}
+			m.object(obj, v, dp, sp, layer)
+			continue
+		case []any:
+			list, ok := existing.([]any)
+			if def, isDefault := listDefaults[key]; !exists && isDefault && dstPath == "" && m.layers[layer].project {
+				list, ok = toAnyList(def), true
+			}
+			if ok {
+				m.origins[dp] = settingOrigin{layer, sp}
+				for i, item := range v {
+					if containsValue(list, item) {
+						continue
+					}
+					m.record(dp+jsonPath(len(list)), sp+jsonPath(i), item, layer)
+					list = append(list, item)
+				}
+				dst[key] = list
+				continue
+			}
+		}
+		dst[key] = value
+		m.record(dp, sp, value, layer)
+	}
+}
+
+// record notes the origin of a replaced value and everything inside it.
+func (m *configMerge) record(dstPath, srcPath string, value any, layer int) {
+	m.origins[dstPath] = settingOrigin{layer, srcPath}
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			m.record(dstPath+jsonKey(false, key), srcPath+jsonKey(false, key), item, layer)
+		}
+	case []any:
+		for i, item := range v {
+			m.record(dstPath+jsonPath(i), srcPath+jsonPath(i), item, layer)
+		}
+	}
+}
+
+func containsValue(list []any, item any) bool {
+	for _, v := range list {
+		if reflect.DeepEqual(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+func toAnyList(values []string) []any {
+	list := make([]any, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+// locate finds the file, line and column a merged setting was set at. Settings
+// nobody set (defaults) are reported against the global file with no line.
+func (c *Config) locate(path string) (string, int, int) {
+	o, ok := c.origins[path]
+	if !ok {
+		return filepath.Base(c.path), 0, 0
+	}
+	layer := c.layers[o.layer]
+	line, column := lineColumn(layer.data, layer.offsets, o.path)
+	return layer.name, line, column
+}
+
+// fromProjectLayer reports whether a merged setting came from a project-local file.
+func (c *Config) fromProjectLayer(path string) bool {
+	o, ok := c.origins[path]
+	return ok && c.layers[o.layer].project
+}
+
+// Layers returns the files and environment variables the config was built
+// from, lowest precedence first.
+func (c *Config) Layers() []string {
+	var names, builtins []string
+	for _, l := range c.layers {
+		switch {
+		case l.builtin:
+			builtins = append(builtins, l.name)
+		case l.file != "":
+			names = append(names, l.file)
+		default:
+			names = append(names, "$"+l.name)
+		}
+	}
+	return append(builtins, names...)
+}
+
+// EffectiveJSON returns the merged config as the hooks use it, with
+// project settings applied. Discovered mappings live in the store and are
+// left out; "sanitizer mappings list" shows those.
+func (c *Config) EffectiveJSON() ([]byte, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	delete(raw, "mappingsAuto")
+	delete(raw, "counters")
+	return json.MarshalIndent(raw, "", "    ")
+}
+
+// isProjectConfigFile reports whether a project-relative path (forward
+// slashes) is one of ProjectConfigFiles.
+func isProjectConfigFile(relPath string) bool {
+	for _, f := range ProjectConfigFiles {
+		if strings.EqualFold(relPath, f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+// lock.go - Cross-process locking and atomic writes for sanitizer.json and mappings.json.
+// Every hook is its own process and Claude runs tool calls in parallel, so
+// several hooks can save at once. Writers hold an OS advisory lock on a
+// sidecar .lock file; readers never lock, so files are replaced atomically.
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockTimeout   = 10 * time.Second      // Give up rather than hang a hook forever
+	lockRetryWait = 20 * time.Millisecond // Poll interval while another hook holds the lock
+)
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// acquireLock takes an exclusive advisory lock (flock / LockFileEx) on path's
+// lock file. The OS releases it when the holder exits, even if it crashed, so
+// there are no stale locks to break. The lock file itself is left in place:
+// deleting it would let two processes lock two different files.
+func acquireLock(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath(path), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", lockPath(path), err)
+		}
+		if ok {
+			return f, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("timed out after %s waiting for lock on %s", lockTimeout, path)
+		}
+		time.Sleep(lockRetryWait)
+	}
+}
+
+func releaseLock(f *os.File) {
+	unlockFile(f)
+	f.Close()
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and renames
+// it over path, so a reader sees the old file or the new one, never half of
+// one, and a crash mid-write leaves the old file intact.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = renameReplace(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// fileWritable reports whether writeFileAtomic could replace path: the file
+// opens for writing and its directory takes a temp file. Checked before
+// saving a file the user may have made read-only on purpose, so every hook
+// doesn't retry (and log) a save that can't work.
+func fileWritable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return true
+}
+
+// renameReplace renames over an existing file. On Windows that fails while
+// another process has the target open (a hook reading it), so retry briefly.
+func renameReplace(from, to string) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Rename(from, to); err == nil {
+			return nil
+		}
+		time.Sleep(lockRetryWait)
+	}
+	return err
+}
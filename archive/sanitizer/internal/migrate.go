@@ -0,0 +1,307 @@
+// migrate.go - sanitizer.json schema versions, upgrades from older layouts, and
+// export/import of the mapping store between machines.
+// Each migration moves the raw JSON map one version forward, so a config from
+// any earlier release loads and fields this release doesn't know survive.
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ConfigVersion is the sanitizer.json schema this release reads and writes.
+//
+//	0 - PowerShell prototype: autoMappings and manualMappings, no version field
+//	1 - Go rewrite: mappingsAuto and counters in sanitizer.json, no version field
+//	2 - "version" field; discovered mappings live in mappings.json (see store.go)
+const ConfigVersion = 2
+
+// migrations[v] upgrades a version v config to v+1.
+var migrations = []func(raw map[string]any){
+	renamePrototypeKeys,    // 0 -> 1
+	upgradeUnsanitizedPath, // 1 -> 2
+}
+
+// prototypeKeys maps the PowerShell prototype's key names to the current ones.
+// autoMappings is what its sanitizer.json files hold; manualMappings is its
+// counterpart for hand-written entries.
+var prototypeKeys = map[string]string{
+	"autoMappings":   "mappingsAuto",
+	"manualMappings": "mappingsManual",
+}
+
+// configVersion reads the "version" field. Files from before it existed are
+// told apart by their keys.
+func configVersion(raw map[string]any) (int, error) {
+	if v, ok := raw["version"]; ok {
+		n, ok := v.(float64) // encoding/json decodes every number as float64
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return 0, fmt.Errorf("sanitizer.json: version must be a whole number, got %v", v)
+		}
+		return int(n), nil
+	}
+	for old := range prototypeKeys {
+		if _, ok := raw[old]; ok {
+			return 0, nil
+		}
+	}
+	return 1, nil
+}
+
+// migrateConfig upgrades raw to ConfigVersion in place and returns the version
+// it started at. A config from a newer release is an error rather than being
+// loaded with its new fields silently ignored.
+func migrateConfig(raw map[string]any) (int, error) {
+	from, err := configVersion(raw)
+	if err != nil {
+		return 0, err
+	}
+	if from > ConfigVersion {
+		return from, fmt.Errorf("sanitizer.json is version %d, this sanitizer reads up to version %d: update the sanitizer", from, ConfigVersion)
+	}
+	for v := from; v < ConfigVersion; v++ {
+		migrations[v](raw)
+	}
+	raw["version"] = ConfigVersion
+	return from, nil
+}
+
+// renamePrototypeKeys moves autoMappings/manualMappings to their current names.
+// If a file has both spellings, the current one wins for a value in both.
+func renamePrototypeKeys(raw map[string]any) {
+	for old, key := range prototypeKeys {
+		value, ok := raw[old]
+		if !ok {
+			continue
+		}
+		delete(raw, old)
+		existing, ok := raw[key].(map[string]any)
+		from, fromOK := value.(map[string]any)
+		if !ok || !fromOK {
+			if _, set := raw[key]; !set {
+				raw[key] = value // Not a map: let json.Unmarshal report it
+			}
+			continue
+		}
+		for real, pseudonym := range from {
+			if _, taken := existing[real]; !taken {
+				existing[real] = pseudonym
+			}
+		}
+	}
+}
+
+// upgradeUnsanitizedPath replaces the old {project} default, which collides
+// for same-named projects (see project.go). A custom path is left alone. The
+// copies themselves move at session start (see MigrateUnsanitizedDir).
+func upgradeUnsanitizedPath(raw map[string]any) {
+	if raw["unsanitizedPath"] == legacyUnsanitizedPath {
+		raw["unsanitizedPath"] = DefaultUnsanitizedPath
+	}
+}
+
+// MigrateUnsanitizedDir moves the project's copy from the old {project}
+// default when the default path is in use. The session hooks call it before
+// they write a copy; ExpandUnsanitizedPath only computes the path, so reading
+// commands like "config validate" never move anything.
+func (c *Config) MigrateUnsanitizedDir(projectPath string) {
+	if c.UnsanitizedPath != "" && c.UnsanitizedPath != DefaultUnsanitizedPath {
+		return
+	}
+	adoptLegacyUnsanitizedDir(expandUnsanitizedPath(legacyUnsanitizedPath, "", projectPath), c.ExpandUnsanitizedPath(projectPath))
+}
+
+// adoptLegacyUnsanitizedDir moves a project's copy from the old {project}
+// default to the {projectId} path that replaced it, so upgrading doesn't
+// leave the real values (and originals kept for redacted files) in a folder
+// nothing syncs to any more. Only while newPath doesn't exist, so a project's
+// copy moves once and the move is logged once.
+func adoptLegacyUnsanitizedDir(oldPath, newPath string) {
+	if _, err := os.Lstat(newPath); !os.IsNotExist(err) {
+		return
+	}
+	if info, err := os.Lstat(oldPath); err != nil || !info.IsDir() {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		log.Printf("sanitizer: could not move unsanitized copy %s: %v", oldPath, err)
+		return
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		log.Printf("sanitizer: could not move unsanitized copy %s: %v", oldPath, err)
+		return
+	}
+	log.Printf("sanitizer: moved unsanitized copy %s to %s (unsanitizedPath is now keyed on {projectId})", oldPath, newPath)
+}
+
+// ExportMappings returns the auto and import entries matching q, plus the
+// template counters, as a plaintext store for "sanitizer mappings import" on
+// another machine. Manual mappings travel with sanitizer.json instead.
+func (c *Config) ExportMappings(q MappingQuery) (*MappingStore, error) {
+	store, err := c.LoadMappingStore()
+	if err != nil {
+		return nil, err
+	}
+	export := &MappingStore{Version: mappingStoreVersion, Counters: store.Counters}
+	for _, e := range store.Query(q) {
+		if e.Origin != OriginManual {
+			export.Entries = append(export.Entries, e)
+		}
+	}
+	return export, nil
+}
+
+// ImportResult counts what ImportMappings did.
+type ImportResult struct {
+	Imported  int // Values new to the local store
+	Known     int // Already in the store with the same pseudonym
+	Conflicts int // Already in the store with another pseudonym, or the pseudonym was taken
+}
+
+// ReadMappingExport reads entries to import from a "mappings export" file or
+// a copy of mappings.json, or the discovered mappings of a sanitizer.json
+// of any version (including the PowerShell prototype's autoMappings).
+func (c *Config) ReadMappingExport(path string) ([]MappingEntry, map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	data = stripBOM(data)
+	if _, ok := parseEnvelope(data); ok {
+		return nil, nil, fmt.Errorf("%s is encrypted: run 'sanitizer mappings export' on the machine that has its key", path)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, ok := raw["entries"]; ok {
+		var store MappingStore
+		if err := json.Unmarshal(data, &store); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if store.Version > mappingStoreVersion {
+			return nil, nil, fmt.Errorf("%s is store version %d, this sanitizer reads up to version %d", path, store.Version, mappingStoreVersion)
+		}
+		return store.Entries, store.Counters, nil
+	}
+
+	if _, err := migrateConfig(raw); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	data, _ = json.Marshal(raw)
+	var legacy struct {
+		MappingsAuto map[string]string `json:"mappingsAuto"`
+		Counters     map[string]int    `json:"counters"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var entries []MappingEntry
+	for real, pseudonym := range legacy.MappingsAuto {
+		entries = append(entries, MappingEntry{Real: real, Pseudonym: pseudonym, Kind: c.ClassifyKind(real)})
+	}
+	return entries, legacy.Counters, nil
+}
+
+// ImportMappings merges entries from another machine into the local store as
+// origin import. Conflicts go the way a concurrent save does (see
+// saveMappingStore): the local store wins.
+//   - same real value, different pseudonym: the local pseudonym is kept and
+//     the imported one is added as retired, so files sanitized on the other
+//     machine still restore
+//   - same pseudonym, different real value: the import gets a fresh pseudonym
+func (c *Config) ImportMappings(entries []MappingEntry, counters map[string]int) (*ImportResult, error) {
+	result := &ImportResult{}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Real < entries[j].Real })
+
+	err := c.updateMappingStore(func(store *MappingStore) bool {
+		for _, source := range []map[string]int{store.Counters, counters} {
+			for kind, n := range source {
+				if n > c.Counters[kind] {
+					c.Counters[kind] = n
+				}
+			}
+		}
+
+		key := func(scope, real string) string { return scope + "\x00" + real }
+		index := make(map[string]int, len(store.Entries))
+		used := make(map[string]bool, len(store.Entries)+len(c.MappingsManual))
+		for i, e := range store.Entries {
+			index[key(e.Scope, e.Real)] = i
+			used[e.Pseudonym] = true
+			for _, old := range e.Retired {
+				used[old] = true
+			}
+		}
+		for _, pseudonym := range c.MappingsManual {
+			used[pseudonym] = true
+		}
+		// retire adds pseudonyms nobody else holds to e's retired list
+		retire := func(e *MappingEntry, pseudonyms ...string) {
+			for _, p := range pseudonyms {
+				if p != "" && !used[p] {
+					e.Retired = append(e.Retired, p)
+					used[p] = true
+				}
+			}
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, in := range entries {
+			if in.Origin == OriginManual || in.Real == "" {
+				continue
+			}
+			if i, ok := index[key(in.Scope, in.Real)]; ok {
+				e := &store.Entries[i]
+				if e.Pseudonym == in.Pseudonym {
+					result.Known++
+				} else {
+					result.Conflicts++
+					retire(e, in.Pseudonym)
+				}
+				retire(e, in.Retired...)
+				if in.LastSeen.After(e.LastSeen) {
+					e.LastSeen = in.LastSeen
+				}
+				continue
+			}
+
+			e := in
+			e.Origin = OriginImport
+			e.Retired = nil
+			if e.Kind == "" {
+				e.Kind = c.ClassifyKind(e.Real)
+			}
+			if e.FirstSeen.IsZero() {
+				e.FirstSeen, e.LastSeen = now, now
+			}
+			if e.Pseudonym == "" || used[e.Pseudonym] {
+				result.Conflicts++
+				e.Pseudonym = c.NewPseudonym(e.Kind, used)
+			}
+			used[e.Pseudonym] = true
+			retire(&e, in.Retired...)
+			store.Entries = append(store.Entries, e)
+			index[key(e.Scope, e.Real)] = len(store.Entries) - 1
+			result.Imported++
+		}
+
+		for kind, n := range c.Counters {
+			if n > store.Counters[kind] {
+				store.Counters[kind] = n
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, c.loadMappingStore()
+}
@@ -0,0 +1,282 @@
+// notebook.go - Jupyter notebook (.ipynb) handling.
+// Notebooks are JSON, but the interesting text lives in cell sources split
+// into line arrays and in outputs next to base64 images. The generic JSON
+// handler would run hostname patterns over base64 (corrupting images and
+// inventing mappings), so notebooks are walked cell by cell instead.
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rich output policies, set via the "notebookOutputs" config field.
+const (
+	NotebookOutputsKeep   = "keep"   // Sanitize text outputs, leave images etc. as-is (default)
+	NotebookOutputsStrip  = "strip"  // Drop rich outputs, keep text/plain
+	NotebookOutputsRedact = "redact" // Replace rich outputs with a text/plain placeholder
+)
+
+// transformNotebook applies fn to cell sources, stream text, error tracebacks,
+// text-like mime outputs and metadata. Base64 payloads (images, attachments)
+// are never passed to fn.
+func transformNotebook(content string, fn func(string) string) (string, bool) {
+	nb, ok := parseNotebook(content)
+	if !ok {
+		return "", false
+	}
+
+	changed := false
+	apply := func(v any) any {
+		out, c := transformJSONValue(v, fn)
+		changed = changed || c
+		return out
+	}
+
+	nb["metadata"] = apply(nb["metadata"])
+	for _, c := range nb["cells"].([]any) {
+		cell, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if source, ok := cell["source"]; ok {
+			cell["source"] = transformMultiline(source, fn, &changed)
+		}
+		cell["metadata"] = apply(cell["metadata"])
+
+		outputs, _ := cell["outputs"].([]any)
+		for _, o := range outputs {
+			output, ok := o.(map[string]any)
+			if !ok {
+				continue
+			}
+			if text, ok := output["text"]; ok { // stream
+				output["text"] = transformMultiline(text, fn, &changed)
+			}
+			for _, key := range []string{"ename", "evalue", "traceback"} { // error
+				if v, ok := output[key]; ok {
+					output[key] = apply(v)
+				}
+			}
+			if data, ok := output["data"].(map[string]any); ok { // execute_result, display_data
+				for mime, v := range data {
+					if !isTextMime(mime) {
+						continue // base64 image, pdf, ...
+					}
+					if _, isStr := v.(string); isStr || isStringList(v) {
+						data[mime] = transformMultiline(v, fn, &changed)
+					} else {
+						data[mime] = apply(v) // application/json is a JSON object
+					}
+				}
+			}
+		}
+	}
+
+	if !changed {
+		return content, true
+	}
+	return marshalNotebook(nb), true
+}
+
+func parseNotebook(content string) (map[string]any, bool) {
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.UseNumber() // Keep execution counts and metadata numbers exactly as written
+	var nb map[string]any
+	if err := dec.Decode(&nb); err != nil {
+		return nil, false
+	}
+	if _, ok := nb["cells"].([]any); !ok {
+		return nil, false // Old nbformat 3 (worksheets) or not a notebook - plain JSON
+	}
+	return nb, true
+}
+
+// marshalNotebook writes the layout Jupyter itself uses (1-space indent, sorted
+// keys, raw UTF-8, trailing newline), so a saved notebook round-trips unchanged.
+func marshalNotebook(nb map[string]any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", " ")
+	enc.Encode(nb)
+	return buf.String()
+}
+
+// transformMultiline handles nbformat's "multiline string": either a string or
+// a list of lines each ending in "\n". The lines are joined so values aren't
+// missed at line boundaries, then split back the same way.
+func transformMultiline(v any, fn func(string) string, changed *bool) any {
+	switch t := v.(type) {
+	case string:
+		out := fn(t)
+		if out != t {
+			*changed = true
+		}
+		return out
+	case []any:
+		if !isStringList(t) {
+			return v
+		}
+		var joined strings.Builder
+		for _, line := range t {
+			joined.WriteString(line.(string))
+		}
+		out := fn(joined.String())
+		if out == joined.String() {
+			return v
+		}
+		*changed = true
+		lines := []any{}
+		for _, line := range strings.SplitAfter(out, "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+	return v
+}
+
+func isStringList(v any) bool {
+	list, ok := v.([]any)
+	if !ok {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// transformJSONValue applies fn to every string (keys included) in a decoded JSON value.
+func transformJSONValue(v any, fn func(string) string) (any, bool) {
+	switch t := v.(type) {
+	case string:
+		out := fn(t)
+		return out, out != t
+	case []any:
+		changed := false
+		for i := range t {
+			var c bool
+			t[i], c = transformJSONValue(t[i], fn)
+			changed = changed || c
+		}
+		return t, changed
+	case map[string]any:
+		changed := false
+		out := make(map[string]any, len(t))
+		for k, item := range t {
+			newKey := fn(k)
+			newItem, c := transformJSONValue(item, fn)
+			out[newKey] = newItem
+			changed = changed || c || newKey != k
+		}
+		return out, changed
+	}
+	return v, false
+}
+
+// isTextMime reports whether an output mime type holds text rather than base64.
+func isTextMime(mime string) bool {
+	return strings.HasPrefix(mime, "text/") || mime == "image/svg+xml" ||
+		mime == "application/javascript" || strings.HasSuffix(mime, "json")
+}
+
+func verifyNotebook(_, out string) error {
+	if _, ok := parseNotebook(out); !ok {
+		return fmt.Errorf("not a valid notebook")
+	}
+	return nil
+}
+
+// ApplyNotebookOutputs strips or redacts rich (non text/plain) outputs from a
+// notebook before it is sanitized. Only used in the sanitize direction - the
+// unsanitized copy keeps whatever the working tree has. Non-notebook data and
+// mode "keep" return data unchanged.
+func ApplyNotebookOutputs(path string, data []byte, mode string) []byte {
+	if mode != NotebookOutputsStrip && mode != NotebookOutputsRedact {
+		return data
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".ipynb") {
+		return data
+	}
+	text, enc, ok := DecodeText(data)
+	if !ok {
+		return data
+	}
+	nb, ok := parseNotebook(text)
+	if !ok {
+		return data
+	}
+
+	changed := false
+	for _, c := range nb["cells"].([]any) {
+		cell, _ := c.(map[string]any)
+		outputs, _ := cell["outputs"].([]any)
+		for _, o := range outputs {
+			output, _ := o.(map[string]any)
+			bundle, ok := output["data"].(map[string]any)
+			if !ok {
+				continue
+			}
+			var removed []string
+			for mime := range bundle {
+				if mime != "text/plain" {
+					removed = append(removed, mime)
+					delete(bundle, mime)
+				}
+			}
+			if len(removed) == 0 {
+				continue
+			}
+			changed = true
+			sort.Strings(removed)
+			if mode == NotebookOutputsRedact {
+				note := "[REDACTED:" + strings.Join(removed, ",") + "]"
+				if plain, ok := bundle["text/plain"]; ok {
+					bundle["text/plain"] = appendMultiline(plain, note)
+				} else {
+					bundle["text/plain"] = note
+				}
+			} else if _, ok := bundle["text/plain"]; !ok {
+				bundle["text/plain"] = "" // A display_data output needs some data
+			}
+			if meta, ok := output["metadata"].(map[string]any); ok {
+				for _, mime := range removed {
+					delete(meta, mime) // e.g. image/png width/height
+				}
+			}
+		}
+		if attachments, ok := cell["attachments"]; ok && attachments != nil {
+			delete(cell, "attachments") // Markdown cell images, always rich
+			changed = true
+		}
+	}
+
+	if !changed {
+		return data
+	}
+	return EncodeText(marshalNotebook(nb), enc)
+}
+
+func appendMultiline(v any, line string) any {
+	switch t := v.(type) {
+	case string:
+		return t + "\n" + line
+	case []any:
+		if n := len(t); n > 0 {
+			if last, ok := t[n-1].(string); ok && !strings.HasSuffix(last, "\n") {
+				t[n-1] = last + "\n"
+			}
+		}
+		return append(t, line)
+	}
+	return line
+}
@@ -0,0 +1,120 @@
+// pathrules.go - Per-path detection rules: which detectors run on which files.
+// Each rule names files by glob (see glob.go) and adjusts detection for them,
+// like a .editorconfig section: extra hostname patterns for an inventory,
+// secrets only for docs, no IP detection for lockfiles full of version numbers.
+package internal
+
+import (
+	"path/filepath"
+	"slices"
+)
+
+// PathRule is one entry of "pathRules". Every rule whose paths match a file
+// applies, in order.
+type PathRule struct {
+	Paths []string `json:"paths"` // Globs relative to the project
+
+	// HostnamePatterns are added to the global hostnamePatterns for these files.
+	HostnamePatterns []string `json:"hostnamePatterns,omitempty"`
+	// Only, if set, lists the detector kinds that run; Disable lists kinds
+	// that don't. Kinds are ip, hostname, privatekey, card, a secretPatterns
+	// kind, or "secrets" for everything but ip and hostname.
+	Only    []string `json:"only,omitempty"`
+	Disable []string `json:"disable,omitempty"`
+	// Skip leaves the files alone entirely, like skipPaths: not sanitized,
+	// not copied to the unsanitized directory.
+	Skip bool `json:"skip,omitempty"`
+}
+
+// KindSecrets stands for every detector kind except ip and hostname in a
+// rule's only and disable lists.
+const KindSecrets = "secrets"
+
+// pathRulesFor returns the rules matching relPath (see ProjectRelative).
+// Files outside the project get none: the globs are project-relative.
+func (c *Config) pathRulesFor(relPath string) []PathRule {
+	if relPath == "" || filepath.IsAbs(relPath) {
+		return nil
+	}
+	var rules []PathRule
+	for _, r := range c.PathRules {
+		if MatchAnyGlob(r.Paths, relPath) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// skipReason explains why a file in the project at root is skipped by
+// skipPaths, an ignore file (see ignore.go) or a pathRules skip, or returns
+// "" if it isn't. Ignore files only count when walk is set: for the session
+// start walk, rotate and sync, not for a single file Claude reads or edits.
+func (c *Config) skipReason(root, relPath string, walk bool) string {
+	if IsSkippedPath(relPath, c.SkipPaths) {
+		return "in skipPaths"
+	}
+	relPath = filepath.ToSlash(relPath)
+	if walk {
+		if source := c.ignoreFor(root).reason(relPath, false); source != "" {
+			return "ignored by " + source
+		}
+	}
+	for _, r := range c.pathRulesFor(relPath) {
+		if r.Skip {
+			return "skipped by pathRules"
+		}
+	}
+	return ""
+}
+
+// skipDir reports whether a walk can skip a whole directory: everything in it
+// is in skipPaths or ignored. pathRules globs are per file, so they don't count.
+func (c *Config) skipDir(root, relPath string) bool {
+	return IsSkippedPath(relPath, c.SkipPaths) || c.ignoreFor(root).reason(filepath.ToSlash(relPath), true) != ""
+}
+
+// DetectorsFor returns the detectors for the file at relPath: Detectors with
+// the matching rules' hostname patterns added and their kinds filtered.
+func (c *Config) DetectorsFor(relPath string) []Detector {
+	rules := c.pathRulesFor(relPath)
+	detectors := c.Detectors()
+	if len(rules) == 0 {
+		return detectors
+	}
+
+	var patterns []string
+	for _, r := range rules {
+		patterns = append(patterns, r.HostnamePatterns...)
+	}
+	if len(patterns) > 0 {
+		detectors = append(detectors, hostnameDetector(patterns))
+	}
+
+	var active []Detector
+	for _, d := range detectors {
+		if kindEnabled(rules, d.Kind) {
+			active = append(active, d)
+		}
+	}
+	return active
+}
+
+// kindEnabled applies every rule's only and disable lists to a detector kind.
+func kindEnabled(rules []PathRule, kind string) bool {
+	for _, r := range rules {
+		if len(r.Only) > 0 && !kindListed(r.Only, kind) {
+			return false
+		}
+		if kindListed(r.Disable, kind) {
+			return false
+		}
+	}
+	return true
+}
+
+func kindListed(kinds []string, kind string) bool {
+	if slices.Contains(kinds, kind) {
+		return true
+	}
+	return kind != "ip" && kind != "hostname" && slices.Contains(kinds, KindSecrets)
+}
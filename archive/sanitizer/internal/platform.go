@@ -0,0 +1,59 @@
+// platform.go - Where the sanitizer lives on each OS: the home directory,
+// Claude's config directory, and the path of the running binary.
+// Like PowerShell's $HOME and $PSCommandPath, resolved once per call.
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HomeDir is the user's home: USERPROFILE when set (Windows, and what the
+// tests point at a temp dir), otherwise $HOME via os.UserHomeDir.
+func HomeDir() string {
+	if home := os.Getenv("USERPROFILE"); home != "" {
+		return home
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+	return ""
+}
+
+// ClaudeDir is Claude Code's config directory: CLAUDE_CONFIG_DIR when set
+// (Claude Code reads it too), otherwise ~/.claude.
+func ClaudeDir() string {
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return filepath.Clean(expandTilde(dir))
+	}
+	return filepath.Join(HomeDir(), ".claude")
+}
+
+// SanitizerExe is the path hook-bash wraps commands with. The running binary
+// is used, so an install outside ~/.claude/sanitizer (or a build without
+// .exe) still works; SanitizerDir is the fallback if the OS can't say.
+func SanitizerExe() string {
+	if exe, err := os.Executable(); err == nil {
+		return exe
+	}
+	return filepath.Join(SanitizerDir(), "sanitizer"+exeSuffix)
+}
+
+// expandHome resolves a leading ~ against HomeDir. Paths under ~/.claude
+// follow ClaudeDir, so the defaults move with CLAUDE_CONFIG_DIR.
+func expandHome(path string) string {
+	slashed := filepath.ToSlash(path)
+	if slashed == "~/.claude" || strings.HasPrefix(slashed, "~/.claude/") {
+		return filepath.Join(ClaudeDir(), path[len("~/.claude"):])
+	}
+	return expandTilde(path)
+}
+
+// expandTilde resolves a leading ~ against HomeDir only.
+func expandTilde(path string) string {
+	if strings.HasPrefix(path, "~") {
+		return filepath.Join(HomeDir(), path[1:])
+	}
+	return path
+}
@@ -0,0 +1,106 @@
+// profile.go - Named sensitivity profiles: one setting that picks detectors,
+// policies, failure mode and read-deny behavior together, instead of tuning
+// each by hand per project. A profile fills in the settings no config layer
+// sets, like a parameter's default value: anything written down still wins.
+package internal
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Profile names for "profile".
+const (
+	ProfileMinimal  = "minimal"
+	ProfileStandard = "standard"
+	ProfileStrict   = "strict"
+)
+
+// profiles holds each profile's settings in sanitizer.json form. standard
+// is the behavior with no profile at all.
+var profiles = map[string]string{
+	ProfileMinimal: `{
+		"disableDetectors": ["privatekey"],
+		"notebookOutputs": "keep",
+		"denyUnprocessedReads": false,
+		"failureMode": "open"
+	}`,
+	ProfileStandard: `{}`,
+	ProfileStrict: `{
+		"secretPatterns": {
+			"aws-key": "\\b(AKIA[0-9A-Z]{16})\\b",
+			"github-token": "\\b(gh[pousr]_[A-Za-z0-9]{36,255})\\b",
+			"password": "(?i)\\b(?:password|passwd|secret|api[_-]?key)\\s*[:=]\\s*[\"']?([^\\s\"']+)"
+		},
+		"policies": {"card": "redact"},
+		"notebookOutputs": "redact",
+		"denyUnprocessedReads": true,
+		"failureMode": "closed"
+	}`,
+}
+
+// ProfileNames returns the profile names, sorted.
+func ProfileNames() []string {
+	return sortedKeys(profiles)
+}
+
+// profileSettings parses a profile's settings; ok is false for an unknown name.
+func profileSettings(name string) (map[string]any, bool) {
+	data, ok := profiles[name]
+	if !ok {
+		return nil, false
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(data), &settings); err != nil {
+		panic("sanitizer: bad built-in profile " + name + ": " + err.Error())
+	}
+	return settings, true
+}
+
+// selectedProfile finds the profile for the working directory in the merged
+// layers: its "projects" entry, else the top-level "profile".
+func selectedProfile(merged map[string]any) string {
+	if dir, err := os.Getwd(); err == nil {
+		want := normalizeProjectPath(dir)
+		projects, _ := merged["projects"].(map[string]any)
+		for path, p := range projects {
+			settings, _ := p.(map[string]any)
+			if name, _ := settings["profile"].(string); name != "" && normalizeProjectPath(path) == want {
+				return name
+			}
+		}
+	}
+	name, _ := merged["profile"].(string)
+	return name
+}
+
+// addProfile applies the selected profile under every other layer. An
+// unknown name applies nothing (validate reports it).
+func (m *configMerge) addProfile() {
+	name := selectedProfile(m.merged)
+	settings, ok := profileSettings(name)
+	if !ok || len(settings) == 0 {
+		return
+	}
+	m.layers = append(m.layers, &configLayer{name: "profile:" + name, builtin: true})
+	m.fill(m.merged, settings, "", len(m.layers)-1)
+}
+
+// fill adds the settings dst doesn't have yet, object keys included: a
+// profile's policies sit beside the config's, not instead of them.
+func (m *configMerge) fill(dst, src map[string]any, path string, layer int) {
+	for _, key := range sortedKeys(src) {
+		value := src[key]
+		p := path + jsonKey(path == "", key)
+		existing, exists := dst[key]
+		if !exists {
+			dst[key] = value
+			m.record(p, p, value, layer)
+			continue
+		}
+		obj, isObj := existing.(map[string]any)
+		if v, ok := value.(map[string]any); ok && isObj {
+			m.fill(obj, v, p, layer)
+		}
+	}
+}
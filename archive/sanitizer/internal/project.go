@@ -0,0 +1,114 @@
+// project.go - Project identity and per-project settings.
+// Projects used to be keyed on their folder name, so ~/work/api and
+// ~/clients/acme/api shared one unsanitized directory. The ID includes a hash
+// of the full path instead, or comes from the "projects" config block.
+package internal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Mapping scopes, set via "mappingScope" globally or per project.
+const (
+	ScopeGlobal  = "global"  // New mappings are shared by every project (default)
+	ScopeProject = "project" // New mappings only apply in the project that found them
+)
+
+// ProjectSettings is one entry of "projects" in sanitizer.json, keyed by the
+// project's path ("~/work/api" or "C:\\code\\api").
+type ProjectSettings struct {
+	ID             string            `json:"id"`             // Stable ID; default <folder>-<path hash>
+	MappingScope   string            `json:"mappingScope"`   // global or project; default: top-level mappingScope
+	MappingsManual map[string]string `json:"mappingsManual"` // Layered over the global mappingsManual
+	Profile        string            `json:"profile"`        // Sensitivity profile; default: top-level profile (see profile.go)
+}
+
+// ProjectID returns the stable ID of the project at projectPath: the "id" of
+// its "projects" entry, else the folder name plus 8 hex digits of a hash of
+// the full path (api-3f9c1a2b). Same folder = same ID on every run.
+func (c *Config) ProjectID(projectPath string) string {
+	if p, ok := c.projectSettings(projectPath); ok && p.ID != "" {
+		return p.ID
+	}
+	path := normalizeProjectPath(projectPath)
+	sum := sha256.Sum256([]byte(path))
+	return fmt.Sprintf("%s-%x", filepath.Base(filepath.FromSlash(path)), sum[:4])
+}
+
+// normalizeProjectPath makes a path comparable: absolute, cleaned, forward
+// slashes, and lowercase on Windows where C:\Code and c:\code are one folder.
+func normalizeProjectPath(path string) string {
+	path = expandHome(path)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(filepath.Clean(path))
+	if runtime.GOOS == "windows" {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// projectSettings finds the "projects" entry for projectPath.
+func (c *Config) projectSettings(projectPath string) (ProjectSettings, bool) {
+	want := normalizeProjectPath(projectPath)
+	for path, p := range c.Projects {
+		if normalizeProjectPath(path) == want {
+			return p, true
+		}
+	}
+	return ProjectSettings{}, false
+}
+
+// applyProject sets up the config for the project in the working directory:
+// its ID, mapping scope and project-level manual mappings, from its
+// "projects" entry or a project-local config file.
+func (c *Config) applyProject() {
+	dir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	c.projectID = c.ProjectID(dir)
+
+	scope := c.MappingScope
+	p, _ := c.projectSettings(dir)
+	if p.MappingScope != "" {
+		scope = p.MappingScope
+	}
+	if strings.EqualFold(scope, ScopeProject) {
+		c.scope = c.projectID
+	}
+
+	if c.projectManual == nil {
+		c.projectManual = make(map[string]bool)
+	}
+	// A project-local file's manual mappings (see layers.go) only apply here
+	for real := range c.MappingsManual {
+		if c.fromProjectLayer(jsonPath("mappingsManual", real)) {
+			c.projectManual[real] = true
+		}
+	}
+	for real, pseudonym := range p.MappingsManual {
+		c.MappingsManual[real] = pseudonym // Project wins over global
+		c.projectManual[real] = true
+	}
+}
+
+// Project returns the ID of the project the config was loaded in.
+func (c *Config) Project() string {
+	return c.projectID
+}
+
+// matchesProject reports whether a stored project ID matches a user-supplied
+// filter: the exact ID, or the folder name of a hashed ID ("api" for api-3f9c1a2b).
+func matchesProject(id, filter string) bool {
+	if strings.EqualFold(id, filter) {
+		return true
+	}
+	return len(id) == len(filter)+9 && strings.EqualFold(id[:len(filter)+1], filter+"-")
+}
@@ -0,0 +1,87 @@
+// restore.go - Putting redacted and hashed values back on the way to the
+// unsanitized directory. Those tokens have no mapping to reverse, so the real
+// values come from the copy being replaced: the original saved at session
+// start (see saveOriginal), or what the last sync restored. Without this a
+// sync would overwrite the only copy of a secret with [REDACTED:kind].
+package internal
+
+import (
+	"fmt"
+	"os"
+)
+
+// tokenRestorer maps the irreversible tokens of one file back to the real
+// values in its unsanitized copy.
+type tokenRestorer struct {
+	hashed   map[string]string   // [HASH:...] -> its value: a hash names one value
+	redacted map[string][]string // [REDACTED:kind] -> the kind's values, in file order
+	missing  int                 // Tokens found with no value left to restore
+}
+
+// newTokenRestorer reads the unsanitized copy at dstPath of the working tree
+// file srcPath and finds the values its pathRules redact or hash. Returns nil
+// if there's no copy or nothing in it to restore.
+func (c *Config) newTokenRestorer(srcPath, dstPath string) *tokenRestorer {
+	data, err := os.ReadFile(dstPath)
+	if err != nil {
+		return nil
+	}
+	text := CollectText(dstPath, data)
+
+	r := &tokenRestorer{hashed: make(map[string]string), redacted: make(map[string][]string)}
+	found := false
+	for _, d := range c.irreversible(c.DetectorsFor(ProjectRelative(srcPath))) {
+		policy := c.PolicyFor(d.Kind)
+		for _, match := range d.Find(text) {
+			if _, manual := c.MappingsManual[match]; manual || irreversibleTokenRegex.MatchString(match) {
+				continue
+			}
+			token := irreversibleToken(d.Kind, policy, match)
+			if policy == PolicyHash {
+				r.hashed[token] = match
+			} else {
+				r.redacted[token] = append(r.redacted[token], match)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return r
+}
+
+// restore replaces the tokens in text. [REDACTED:kind] tokens all look the
+// same, so they take the copy's values in order: right as long as the
+// redacted values weren't reordered or added to.
+func (r *tokenRestorer) restore(text string) string {
+	return irreversibleTokenRegex.ReplaceAllStringFunc(text, func(token string) string {
+		if value, ok := r.hashed[token]; ok {
+			return value
+		}
+		if values := r.redacted[token]; len(values) > 0 {
+			r.redacted[token] = values[1:]
+			return values[0]
+		}
+		r.missing++
+		return token
+	})
+}
+
+// restoreTokens puts the real values back into content bound for dstPath. It
+// fails, and nothing should be written, if content has a token the copy there
+// can't account for while holding secrets that writing would destroy.
+func (c *Config) restoreTokens(srcPath, dstPath string, content []byte) ([]byte, error) {
+	r := c.newTokenRestorer(srcPath, dstPath)
+	if r == nil || !irreversibleTokenRegex.Match(content) {
+		return content, nil
+	}
+	restored, err := TransformBytes(srcPath, content, r.restore)
+	if err != nil {
+		return nil, err
+	}
+	if r.missing > 0 {
+		return nil, fmt.Errorf("kept %s: %d redacted value(s) have no original to restore from", dstPath, r.missing)
+	}
+	return restored, nil
+}
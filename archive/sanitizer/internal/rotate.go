@@ -0,0 +1,175 @@
+// rotate.go - Mapping store maintenance: pruning stale entries and rotating pseudonyms.
+// Run from a terminal ("sanitizer mappings gc|rotate"), never by Claude.
+package internal
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PruneMappings removes auto and import entries whose lastSeen is before
+// cutoff and returns them. lastSeen moves when a detector finds the value or
+// when session stop restores its pseudonym, so a value still sitting in a
+// working tree that has had a session since cutoff is kept. Manual entries
+// are never pruned. With dryRun the store is not changed.
+func (c *Config) PruneMappings(cutoff time.Time, dryRun bool) ([]MappingEntry, error) {
+	var removed []MappingEntry
+	err := c.updateMappingStore(func(store *MappingStore) bool {
+		kept := store.Entries[:0]
+		for _, e := range store.Entries {
+			if e.Origin != OriginManual && e.LastSeen.Before(cutoff) {
+				removed = append(removed, e)
+				continue
+			}
+			kept = append(kept, e)
+		}
+		store.Entries = kept
+		return !dryRun && len(removed) > 0
+	})
+	return removed, err
+}
+
+// RotateMappings gives every auto and import entry matching q a fresh
+// pseudonym and returns old -> new. The old pseudonym is kept on the entry as
+// retired: it is never issued again, still restores to the real value, and is
+// rewritten to the new one wherever the sanitizer meets it (see addRetired).
+func (c *Config) RotateMappings(q MappingQuery) (map[string]string, error) {
+	renames := make(map[string]string)
+	err := c.updateMappingStore(func(store *MappingStore) bool {
+		for kind, n := range store.Counters {
+			if n > c.Counters[kind] {
+				c.Counters[kind] = n
+			}
+		}
+		used := make(map[string]bool)
+		for _, e := range store.Entries {
+			used[e.Pseudonym] = true
+			for _, old := range e.Retired {
+				used[old] = true
+			}
+		}
+		for _, pseudonym := range c.MappingsManual {
+			used[pseudonym] = true
+		}
+
+		for i := range store.Entries {
+			e := &store.Entries[i]
+			if e.Origin == OriginManual || !q.Matches(*e) {
+				continue
+			}
+			pseudonym := c.NewPseudonym(e.Kind, used)
+			used[pseudonym] = true
+			renames[e.Pseudonym] = pseudonym
+			e.Retired = append(e.Retired, e.Pseudonym)
+			e.Pseudonym = pseudonym
+		}
+		for kind, n := range c.Counters {
+			if n > store.Counters[kind] {
+				store.Counters[kind] = n
+			}
+		}
+		return len(renames) > 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renames, c.loadMappingStore()
+}
+
+// RewriteProject replaces rotated pseudonyms in the project's sanitized files
+// and returns how many files changed. The unsanitized copy holds real values
+// and needs no change.
+func (c *Config) RewriteProject(projectPath string, renames map[string]string) (int, error) {
+	if len(renames) == 0 {
+		return 0, nil
+	}
+	replace := c.renamer(renames)
+
+	// Ignored files count: a Read sanitizes them too (see readSkipReason)
+	changed := 0
+	err := filepath.Walk(projectPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			if rel, _ := filepath.Rel(projectPath, path); rel != "." && IsSkippedPath(rel, c.SkipPaths) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err != nil || readSkipReason(path, info, projectPath, c) != "" {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		rewritten, err := TransformBytes(path, content, replace)
+		if err != nil {
+			log.Printf("sanitizer: %v", err)
+			return nil
+		}
+		if bytes.Equal(rewritten, content) {
+			return nil
+		}
+		if err := os.WriteFile(path, rewritten, info.Mode()); err != nil {
+			return err
+		}
+		changed++
+		return nil
+	})
+	return changed, err
+}
+
+// renamer replaces old pseudonyms with new ones in a single pass. Every other
+// known pseudonym maps to itself, so with longest match first 10.99.0.1 is not
+// replaced inside an unrelated 10.99.0.15.
+func (c *Config) renamer(renames map[string]string) func(string) string {
+	pairs := make(map[string]string)
+	for _, pseudonym := range c.AllMappings() {
+		pairs[pseudonym] = pseudonym
+	}
+	for old, pseudonym := range renames {
+		pairs[old] = pseudonym
+	}
+
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	// strings.Replacer tries old strings in argument order at each position
+	args := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		args = append(args, k, pairs[k])
+	}
+	return strings.NewReplacer(args...).Replace
+}
+
+// updateMappingStore loads the store under its lock, lets edit change it, and
+// saves it if edit returns true.
+func (c *Config) updateMappingStore(edit func(store *MappingStore) bool) error {
+	path := mappingStorePathFor(c.path)
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(lock)
+
+	store, err := LoadMappingStoreFrom(path, c.Encryption)
+	if err != nil {
+		return err
+	}
+	applySightingsLog(store, path) // gc decides on lastSeen: it has to be current
+	if !edit(store) {
+		return nil // The log stays for the next save
+	}
+	if err := store.SaveTo(path); err != nil {
+		return err
+	}
+	removeSightingsLog(path)
+	return nil
+}
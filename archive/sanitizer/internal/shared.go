@@ -0,0 +1,471 @@
+// shared.go - Team-shared mapping file, committed with the project.
+// One line per pseudonym, sorted, so it diffs and merges like any text file.
+// "sanitizer mappings merge" folds it into the local store and back, resolving
+// conflicts the same way on every machine so teammates converge.
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSharedMappingsPath is relative to the project root. Inside .claude,
+// so session start never sanitizes it and hook-file-access blocks it.
+const DefaultSharedMappingsPath = ".claude/sanitizer/shared-mappings.txt"
+
+const (
+	sharedHeader  = "# claude-sanitizer shared mappings v1"
+	sharedAAD     = "claude-sanitizer/shared/v1"
+	sharedCurrent = "current"
+	sharedRetired = "retired"
+)
+
+// SharedMappingsConfig is the "sharedMappings" config block.
+type SharedMappingsConfig struct {
+	Path    string `json:"path"`    // Relative to the project; default .claude/sanitizer/shared-mappings.txt
+	KeyFile string `json:"keyFile"` // Team key (64 hex chars, shared out of band); set = hashed file
+}
+
+// SharedMapping is one line of the shared file.
+type SharedMapping struct {
+	Pseudonym string
+	Kind      string
+	Real      string
+	Retired   bool // An earlier pseudonym of Real: restores to it, rewritten to the current one
+}
+
+// MergeResult summarizes a MergeSharedMappings run.
+type MergeResult struct {
+	Imported  int               // Values new to the local store
+	Exported  int               // Local values new to the shared file
+	Conflicts int               // Pseudonyms reassigned to make both sides agree
+	Renamed   map[string]string // Local pseudonym changes, old -> new
+	Files     int               // Working tree files rewritten for Renamed
+}
+
+// SharedMappingsPath returns the shared file for the project at projectPath.
+func (c *Config) SharedMappingsPath(projectPath string) string {
+	path := c.SharedMappings.Path
+	if path == "" {
+		path = DefaultSharedMappingsPath
+	}
+	return filepath.Join(projectPath, filepath.FromSlash(path))
+}
+
+// teamKey loads the key for hashed shared files, or nil for plaintext ones.
+func (c *Config) teamKey() ([]byte, error) {
+	if c.SharedMappings.KeyFile == "" {
+		return nil, nil
+	}
+	key, err := readKeyFile(expandHome(c.SharedMappings.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("shared mappings team key: %w", err)
+	}
+	return key, nil
+}
+
+// sharedCrypto hashes and encrypts real values for a hashed shared file. The
+// encryption is deterministic (nonce derived from the value) so an unchanged
+// value writes an unchanged line and the file only diffs where mappings did.
+type sharedCrypto struct {
+	hashKey, nonceKey []byte
+	gcm               cipher.AEAD
+}
+
+func newSharedCrypto(teamKey []byte) (*sharedCrypto, error) {
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, teamKey)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedCrypto{hashKey: derive("hash"), nonceKey: derive("nonce"), gcm: gcm}, nil
+}
+
+func (s *sharedCrypto) hash(real string) string {
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte(real))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (s *sharedCrypto) seal(real string) string {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	mac.Write([]byte(real))
+	nonce := mac.Sum(nil)[:s.gcm.NonceSize()]
+	sealed := s.gcm.Seal(append([]byte{}, nonce...), nonce, []byte(real), []byte(sharedAAD))
+	return "enc:" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func (s *sharedCrypto) open(hash, enc string) (string, error) {
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(enc, "enc:"))
+	if err != nil || len(data) < s.gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	n := s.gcm.NonceSize()
+	plain, err := s.gcm.Open(nil, data[:n], data[n:], []byte(sharedAAD))
+	if err != nil {
+		return "", fmt.Errorf("wrong team key or corrupted value")
+	}
+	if s.hash(string(plain)) != hash {
+		return "", fmt.Errorf("hash does not match value")
+	}
+	return string(plain), nil
+}
+
+// ReadSharedMappings parses a shared file. Lines left by a git conflict
+// (<<<<<<<, =======, >>>>>>>) are skipped, so both sides of a conflict are
+// read and merged. A missing file is empty. teamKey is needed for hashed files.
+func ReadSharedMappings(path string, teamKey []byte) ([]SharedMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var crypto *sharedCrypto
+	var mappings []SharedMapping
+	scanner := bufio.NewScanner(bytes.NewReader(stripBOM(data)))
+	scanner.Buffer(make([]byte, 64*1024), MaxFileSize)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") || isConflictMarker(line) {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		for i, f := range fields {
+			if strings.HasPrefix(f, `"`) {
+				if fields[i], err = strconv.Unquote(f); err != nil {
+					return nil, fmt.Errorf("%s:%d: bad quoted field", path, lineNo)
+				}
+			}
+		}
+		if len(fields) != 4 && len(fields) != 5 || fields[2] != sharedCurrent && fields[2] != sharedRetired {
+			return nil, fmt.Errorf("%s:%d: expected pseudonym, kind, current|retired, value", path, lineNo)
+		}
+
+		m := SharedMapping{Pseudonym: fields[0], Kind: fields[1], Retired: fields[2] == sharedRetired, Real: fields[3]}
+		if len(fields) == 5 { // hmac:..., enc:...
+			if teamKey == nil {
+				return nil, fmt.Errorf("%s is hashed: set sharedMappings.keyFile to the team key", path)
+			}
+			if crypto == nil {
+				if crypto, err = newSharedCrypto(teamKey); err != nil {
+					return nil, err
+				}
+			}
+			if m.Real, err = crypto.open(fields[3], fields[4]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, scanner.Err()
+}
+
+func isConflictMarker(line string) bool {
+	for _, marker := range []string{"<<<<<<<", "=======", ">>>>>>>", "|||||||"} {
+		if strings.HasPrefix(line, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSharedMappings writes mappings sorted by pseudonym, one per line:
+//
+//	pseudonym <TAB> kind <TAB> current|retired <TAB> real
+//
+// With a team key the real value is replaced by "hmac:..." and "enc:..."
+// columns. Fields holding tabs, newlines or a leading quote are Go-quoted.
+func WriteSharedMappings(path string, mappings []SharedMapping, teamKey []byte) error {
+	var crypto *sharedCrypto
+	if teamKey != nil {
+		var err error
+		if crypto, err = newSharedCrypto(teamKey); err != nil {
+			return err
+		}
+	}
+
+	sorted := append([]SharedMapping{}, mappings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Pseudonym < sorted[j].Pseudonym })
+
+	var b strings.Builder
+	b.WriteString(sharedHeader + "\n")
+	b.WriteString("# Managed by \"sanitizer mappings merge\". On a git conflict, keep both sides and run it again.\n")
+	for _, m := range sorted {
+		state := sharedCurrent
+		if m.Retired {
+			state = sharedRetired
+		}
+		fields := []string{m.Pseudonym, m.Kind, state}
+		if crypto != nil {
+			fields = append(fields, crypto.hash(m.Real), crypto.seal(m.Real))
+		} else {
+			fields = append(fields, m.Real)
+		}
+		for i, f := range fields {
+			if strings.ContainsAny(f, "\t\r\n") || strings.HasPrefix(f, `"`) {
+				fields[i] = strconv.Quote(f)
+			}
+		}
+		b.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	return writeFileAtomic(path, []byte(b.String()), 0644)
+}
+
+// sharedClaim collects every pseudonym seen for one real value.
+type sharedClaim struct {
+	kind    string
+	current map[string]bool
+	retired map[string]bool
+	local   int // Index of the local store entry, -1 if only in the shared file
+}
+
+// MergeSharedMappings merges the project's shared file with the local store
+// and writes both back. Local values that go into the file are those first
+// seen in or scoped to this project, plus any value the file already lists.
+//
+// Conflicts are resolved from the merged data alone, so every teammate gets
+// the same answer:
+//   - A value with two pseudonyms keeps the lowest; the others become retired
+//   - A pseudonym claimed by two values goes to the lowest value, unless it is
+//     taken locally by a mapping outside the merge; the loser gets a new one
+//
+// Local pseudonym changes are rewritten in projectPath's working tree (as by
+// "mappings rotate") before the store is saved, so no file is left holding a
+// pseudonym that now means something else.
+func (c *Config) MergeSharedMappings(projectPath string) (*MergeResult, error) {
+	key, err := c.teamKey()
+	if err != nil {
+		return nil, err
+	}
+	path := c.SharedMappingsPath(projectPath)
+	if rel, _ := filepath.Rel(projectPath, path); !strings.HasPrefix(filepath.ToSlash(rel), ".claude/") {
+		// Anywhere else, session start would sanitize the file's real values
+		return nil, fmt.Errorf("sharedMappings.path must be inside the project's .claude directory")
+	}
+	shared, err := ReadSharedMappings(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	source := filepath.ToSlash(c.SharedMappings.Path)
+	if source == "" {
+		source = DefaultSharedMappingsPath
+	}
+
+	result := &MergeResult{Renamed: make(map[string]string)}
+	var merged []SharedMapping
+	var rewriteErr error
+	err = c.updateMappingStore(func(store *MappingStore) bool {
+		for kind, n := range store.Counters {
+			if n > c.Counters[kind] {
+				c.Counters[kind] = n
+			}
+		}
+
+		inShared := make(map[string]bool)
+		for _, m := range shared {
+			inShared[m.Real] = true
+		}
+
+		// Gather claims from the selected local entries and the file
+		claims := make(map[string]*sharedClaim)
+		claim := func(real, kind string) *sharedClaim {
+			cl, ok := claims[real]
+			if !ok {
+				cl = &sharedClaim{kind: kind, current: make(map[string]bool), retired: make(map[string]bool), local: -1}
+				claims[real] = cl
+			}
+			return cl
+		}
+		selected := make(map[int]bool)
+		for i, e := range store.Entries {
+			if e.Origin == OriginManual || (e.Scope != "" && e.Scope != c.scope) {
+				continue
+			}
+			if _, manual := c.MappingsManual[e.Real]; manual {
+				continue
+			}
+			if e.Project != c.projectID && e.Scope != c.projectID && !inShared[e.Real] {
+				continue
+			}
+			// A project-scoped entry hides a global one for the same value
+			if prev, ok := claims[e.Real]; ok && prev.local >= 0 && e.Scope == "" {
+				continue
+			}
+			cl := claim(e.Real, e.Kind)
+			cl.local = i
+			cl.current = map[string]bool{e.Pseudonym: true}
+			cl.retired = make(map[string]bool)
+			for _, old := range e.Retired {
+				cl.retired[old] = true
+			}
+			selected[i] = true
+		}
+		for real := range claims {
+			if !inShared[real] {
+				result.Exported++
+			}
+		}
+		for _, m := range shared {
+			if _, manual := c.MappingsManual[m.Real]; manual {
+				continue // Local manual mapping wins, for this machine only
+			}
+			cl := claim(m.Real, m.Kind)
+			if m.Retired {
+				cl.retired[m.Pseudonym] = true
+			} else {
+				cl.current[m.Pseudonym] = true
+			}
+		}
+
+		// Pseudonyms held by mappings outside the merge can't move
+		owner := make(map[string]string)
+		used := make(map[string]bool)
+		for i, e := range store.Entries {
+			used[e.Pseudonym] = true
+			for _, old := range e.Retired {
+				used[old] = true
+			}
+			if !selected[i] {
+				owner[e.Pseudonym] = e.Real
+				for _, old := range e.Retired {
+					owner[old] = e.Real
+				}
+			}
+		}
+		for real, pseudonym := range c.MappingsManual {
+			owner[pseudonym] = real
+			used[pseudonym] = true
+		}
+		for _, cl := range claims {
+			for p := range cl.current {
+				used[p] = true
+			}
+			for p := range cl.retired {
+				used[p] = true
+			}
+		}
+
+		// Each pseudonym goes to one value: outside owner, else the lowest claimant
+		reals := make([]string, 0, len(claims))
+		for real := range claims {
+			reals = append(reals, real)
+		}
+		sort.Strings(reals)
+		for _, real := range reals {
+			cl := claims[real]
+			for _, set := range []map[string]bool{cl.current, cl.retired} {
+				for p := range set {
+					if o, ok := owner[p]; ok && o != real {
+						delete(set, p)
+						result.Conflicts++
+					} else if !ok {
+						owner[p] = real // reals is sorted, so the lowest claims first
+					}
+				}
+			}
+		}
+
+		// One current pseudonym per value: the lowest, or a new one if all were lost
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, real := range reals {
+			cl := claims[real]
+			var current string
+			if len(cl.current) > 0 {
+				current = sortedKeys(cl.current)[0]
+			} else {
+				current = c.NewPseudonym(cl.kind, used)
+				used[current] = true
+				owner[current] = real
+			}
+			if len(cl.current) > 1 {
+				result.Conflicts += len(cl.current) - 1
+			}
+			for p := range cl.current {
+				if p != current {
+					cl.retired[p] = true
+				}
+			}
+			delete(cl.retired, current)
+
+			if cl.local >= 0 {
+				e := &store.Entries[cl.local]
+				if e.Pseudonym != current {
+					result.Renamed[e.Pseudonym] = current
+				}
+				// Retired pseudonyms lost to another value must be rewritten here too
+				for _, old := range e.Retired {
+					if !cl.retired[old] && old != current {
+						result.Renamed[old] = current
+					}
+				}
+				e.Pseudonym = current
+				e.Retired = sortedKeys(cl.retired)
+			} else {
+				result.Imported++
+				store.Entries = append(store.Entries, MappingEntry{
+					Real: real, Pseudonym: current, Kind: cl.kind, Origin: OriginImport, Scope: c.scope,
+					Project: c.projectID, Source: source, FirstSeen: now, LastSeen: now, Retired: sortedKeys(cl.retired),
+				})
+			}
+
+			merged = append(merged, SharedMapping{Pseudonym: current, Kind: cl.kind, Real: real})
+			for _, old := range sortedKeys(cl.retired) {
+				merged = append(merged, SharedMapping{Pseudonym: old, Kind: cl.kind, Real: real, Retired: true})
+			}
+		}
+		for kind, n := range c.Counters {
+			if n > store.Counters[kind] {
+				store.Counters[kind] = n
+			}
+		}
+
+		// Rewrite before saving: if this fails, the store still matches the tree
+		result.Files, rewriteErr = c.RewriteProject(projectPath, result.Renamed)
+		return rewriteErr == nil
+	})
+	if err == nil {
+		err = rewriteErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteSharedMappings(path, merged, key); err != nil {
+		return nil, err
+	}
+	return result, c.loadMappingStore()
+}
+
+// sortedKeys returns m's keys in order, so output doesn't depend on map order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
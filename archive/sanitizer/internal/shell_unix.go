@@ -0,0 +1,20 @@
+//go:build !windows
+
+// shell_unix.go - Linux/macOS: commands run under pwsh (PowerShell 7) when
+// it's installed, since the commands hook-bash routes here are PowerShell
+// ones; otherwise under /bin/sh, which runs "pwsh ..." and "./script.ps1"
+// with a shebang just as well.
+package internal
+
+import "os/exec"
+
+// exeSuffix is the file extension of executables.
+const exeSuffix = ""
+
+// shellCommand builds the command "sanitizer exec" runs.
+func shellCommand(command string) *exec.Cmd {
+	if pwsh, err := exec.LookPath("pwsh"); err == nil {
+		return exec.Command(pwsh, "-NoProfile", "-Command", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
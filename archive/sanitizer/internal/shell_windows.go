@@ -0,0 +1,14 @@
+// shell_windows.go - Windows: commands run under Windows PowerShell, which
+// every supported Windows version ships.
+package internal
+
+import "os/exec"
+
+// exeSuffix is the file extension of executables.
+const exeSuffix = ".exe"
+
+// shellCommand builds the command "sanitizer exec" runs.
+// -NoProfile skips loading the PowerShell profile for faster startup.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("powershell.exe", "-NoProfile", "-Command", command)
+}
@@ -0,0 +1,132 @@
+// sightings.go - Hits and lastSeen for values already in the store. Most hook
+// calls find nothing new, only values seen before; rewriting the whole store
+// (locked, atomic, re-encrypted) to bump a counter would cost every tool call
+// that much. Instead the hook appends a line to mappings.sightings, and the
+// log is folded into the store at the next full save: a new mapping, session
+// stop, "mappings gc"/"rotate", or once the log passes sightingsFlushSize.
+// Like Start-Transcript: cheap appends now, read back later.
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sightingsFlushSize is the log size at which a hook folds it in itself, so
+// a long session without a stop doesn't grow it without bound.
+const sightingsFlushSize = 64 << 10
+
+// sightingLine is one hook's sighting of one stored value. Keyed by pseudonym,
+// which is unique across the store and already known to Claude: the log is
+// never encrypted, so it must not hold real values.
+type sightingLine struct {
+	Pseudonym string    `json:"pseudonym"`
+	Hits      int       `json:"hits,omitempty"` // 0 = restored at session stop, not found by a detector
+	Seen      time.Time `json:"seen"`
+}
+
+// sightingsLogPath is the log next to the store.
+func sightingsLogPath(storePath string) string {
+	return filepath.Join(filepath.Dir(storePath), "mappings.sightings")
+}
+
+// appendSightings logs the sightings and restored values recorded since the
+// last save. Returns false, having written nothing, if a manual mapping's
+// store entry is missing or out of date: only a full save can fix it.
+func (c *Config) appendSightings() (bool, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for real, s := range c.sightings {
+		if pseudonym, manual := c.MappingsManual[real]; manual && c.manualStored[real] != pseudonym {
+			return false, nil // First sighting, or edited in sanitizer.json since: the entry needs saving
+		}
+		if pseudonym, ok := c.storedPseudonym(real); ok {
+			_ = enc.Encode(sightingLine{Pseudonym: pseudonym, Hits: s.hits, Seen: now})
+		}
+	}
+	for real := range c.restored {
+		if pseudonym, ok := c.storedPseudonym(real); ok {
+			_ = enc.Encode(sightingLine{Pseudonym: pseudonym, Seen: now})
+		}
+	}
+
+	storePath := mappingStorePathFor(c.path)
+	lock, err := acquireLock(storePath) // A flush between our read and write would lose the lines
+	if err != nil {
+		return false, err
+	}
+	defer releaseLock(lock)
+
+	f, err := os.OpenFile(sightingsLogPath(storePath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	c.sightings = nil
+	c.restored = nil
+	return true, nil
+}
+
+// storedPseudonym returns the pseudonym of real's store entry, if it has one.
+func (c *Config) storedPseudonym(real string) (string, bool) {
+	if _, manual := c.MappingsManual[real]; manual {
+		pseudonym, ok := c.manualStored[real]
+		return pseudonym, ok
+	}
+	pseudonym, ok := c.MappingsAuto[real]
+	return pseudonym, ok
+}
+
+// sightingsLogFull reports whether the log has grown enough to fold in now.
+func sightingsLogFull(storePath string) bool {
+	info, err := os.Stat(sightingsLogPath(storePath))
+	return err == nil && info.Size() >= sightingsFlushSize
+}
+
+// applySightingsLog adds the logged sightings to the store's entries. Lines
+// for pseudonyms no longer in the store (pruned, or a retired pseudonym) are
+// dropped. Returns whether the log had any lines. Callers hold the store lock
+// and remove the log once the store is saved.
+func applySightingsLog(store *MappingStore, storePath string) bool {
+	data, err := os.ReadFile(sightingsLogPath(storePath))
+	if err != nil || len(data) == 0 {
+		return false
+	}
+	index := make(map[string]int, len(store.Entries))
+	for i, e := range store.Entries {
+		index[e.Pseudonym] = i
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line sightingLine
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue // Torn by a crash mid-append
+		}
+		i, ok := index[line.Pseudonym]
+		if !ok {
+			continue
+		}
+		e := &store.Entries[i]
+		e.Hits += line.Hits
+		if line.Seen.After(e.LastSeen) {
+			e.LastSeen = line.Seen
+		}
+	}
+	return true
+}
+
+// removeSightingsLog deletes the log once its lines are in the saved store.
+func removeSightingsLog(storePath string) {
+	_ = os.Remove(sightingsLogPath(storePath))
+}
@@ -0,0 +1,233 @@
+// snapshot.go - Snapshot of the merged config, reused by later hook calls.
+// Every hook is a new process that would otherwise merge the layers, migrate,
+// validate and parse the whole mapping store again. The snapshot is the result
+// of all that as JSON, kept in ~/.claude/sanitizer/cache/ and thrown away as
+// soon as any file it was built from changes. mappings.json is one of them,
+// but only moves for new mappings (see sightings.go).
+//
+// It is not a compiled cache. Go can't save a compiled regex or replacer, so
+// every process still compiles its detectors and blocked paths and builds the
+// mapping replacer from scratch; and with an encrypted store no snapshot is
+// written at all. Like PowerShell's module analysis cache: a list of what a
+// module exports, not the loaded module.
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// snapshotVersion changes whenever configSnapshot or what goes into it changes.
+const snapshotVersion = 2
+
+// NoCacheEnv turns the snapshot off (any non-empty value), for debugging.
+const NoCacheEnv = "SANITIZER_NO_CACHE"
+
+// racyWindow: a file modified this recently may change again without its
+// size or timestamp moving (coarse filesystem clocks), so no snapshot is
+// written until it has settled. Same idea as git's "racy clean" index entries.
+const racyWindow = 2 * time.Second
+
+// fileStamp identifies one version of a file the snapshot was built from.
+type fileStamp struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`    // -1 = didn't exist, which counts too
+	ModTime int64  `json:"modTime"` // UnixNano
+}
+
+// configSnapshot is a loaded Config: the exported fields as JSON (store
+// mappings already applied) plus the runtime state LoadConfigFrom computes.
+type configSnapshot struct {
+	Version  int         `json:"version"`
+	Key      string      `json:"key"` // Working dir, env overrides, binary
+	Files    []fileStamp `json:"files"`
+	Problems string      `json:"problems,omitempty"` // Logged on every load, as a full load would
+
+	Config        json.RawMessage   `json:"config"`
+	LegacyAuto    map[string]string `json:"legacyAuto,omitempty"`
+	ProjectID     string            `json:"projectId"`
+	Scope         string            `json:"scope"`
+	ProjectManual map[string]bool   `json:"projectManual,omitempty"`
+	Retired       map[string]string `json:"retired,omitempty"`
+	ManualStored  map[string]string `json:"manualStored,omitempty"`
+}
+
+// lastSnapshot saves re-reading the cache file when one process loads the
+// config more than once (hook-file-access, then SanitizeSingleFile).
+var lastSnapshot struct {
+	sync.Mutex
+	file string
+	snap *configSnapshot
+}
+
+// snapshotPath is the snapshot file for the working directory: project
+// settings and project-local config make each directory's config different.
+func snapshotPath(configPath, projectPath string) string {
+	sum := sha256.Sum256([]byte(normalizeProjectPath(projectPath)))
+	return filepath.Join(filepath.Dir(configPath), "cache", hex.EncodeToString(sum[:8])+".json")
+}
+
+// snapshotKey covers the inputs that aren't files: where we are, which
+// environment overrides are set, and which build of the sanitizer is reading.
+func snapshotKey(projectPath string) string {
+	h := sha256.New()
+	h.Write([]byte(normalizeProjectPath(projectPath) + "\x00"))
+	for _, env := range envOverrides {
+		value, ok := os.LookupEnv(env.name)
+		if ok {
+			h.Write([]byte(env.name + "=" + value))
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{Path: path, Size: -1}
+	}
+	return fileStamp{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+}
+
+// snapshotFiles lists everything the config was built from, including the
+// project config files and includes that don't exist yet: creating one has
+// to invalidate the snapshot as surely as editing one.
+func (c *Config) snapshotFiles(projectPath string) []string {
+	files := []string{c.path, mappingStorePathFor(c.path)}
+	for _, rel := range ProjectConfigFiles {
+		files = append(files, filepath.Join(projectPath, rel))
+	}
+	for _, l := range c.layers {
+		if l.file != "" {
+			files = append(files, l.file)
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		files = append(files, exe) // A rebuilt sanitizer may load differently
+	}
+	return files
+}
+
+// loadCachedConfig returns the config from a still-valid snapshot, or nil.
+func loadCachedConfig(configPath, projectPath string) *Config {
+	file := snapshotPath(configPath, projectPath)
+	lastSnapshot.Lock()
+	snap := lastSnapshot.snap
+	if lastSnapshot.file != file {
+		snap = nil
+	}
+	lastSnapshot.Unlock()
+
+	if snap == nil {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil
+		}
+		snap = &configSnapshot{}
+		if err := json.Unmarshal(data, snap); err != nil {
+			return nil // Half-written by an older build, or edited: rebuild it
+		}
+	}
+	if snap.Version != snapshotVersion || snap.Key != snapshotKey(projectPath) {
+		return nil
+	}
+	for _, f := range snap.Files {
+		if stampFile(f.Path) != f {
+			return nil
+		}
+	}
+
+	cfg := &Config{path: configPath}
+	if err := json.Unmarshal(snap.Config, cfg); err != nil {
+		return nil
+	}
+	// Each load gets its own maps; the hooks change them as they go
+	cfg.legacyAuto = cloneMap(snap.LegacyAuto)
+	cfg.projectID, cfg.scope = snap.ProjectID, snap.Scope
+	cfg.projectManual = cloneMap(snap.ProjectManual)
+	cfg.retired = cloneMap(snap.Retired)
+	cfg.manualStored = cloneMap(snap.ManualStored)
+	if cfg.MappingsManual == nil {
+		cfg.MappingsManual = make(map[string]string)
+	}
+	if cfg.MappingsAuto == nil {
+		cfg.MappingsAuto = make(map[string]string)
+	}
+	if cfg.Counters == nil {
+		cfg.Counters = make(map[string]int)
+	}
+
+	lastSnapshot.Lock()
+	lastSnapshot.file, lastSnapshot.snap = file, snap
+	lastSnapshot.Unlock()
+	if snap.Problems != "" {
+		log.Print(snap.Problems)
+	}
+	return cfg
+}
+
+// saveConfigSnapshot writes the snapshot of a config LoadConfigFrom just
+// loaded. Skipped when the store is encrypted: the snapshot holds every
+// mapping in plaintext, which is what encryption is there to prevent.
+func (c *Config) saveConfigSnapshot(projectPath string) {
+	if c.storeEncrypted || c.Encryption.Key != "" {
+		return
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	snap := &configSnapshot{
+		Version:       snapshotVersion,
+		Key:           snapshotKey(projectPath),
+		Problems:      c.loadProblems,
+		Config:        data,
+		LegacyAuto:    c.legacyAuto,
+		ProjectID:     c.projectID,
+		Scope:         c.scope,
+		ProjectManual: c.projectManual,
+		Retired:       c.retired,
+		ManualStored:  c.manualStored,
+	}
+	now := time.Now()
+	for _, path := range c.snapshotFiles(projectPath) {
+		stamp := stampFile(path)
+		if stamp.Size >= 0 && now.Sub(time.Unix(0, stamp.ModTime)) < racyWindow {
+			return // Not settled yet; a later load will write it
+		}
+		snap.Files = append(snap.Files, stamp)
+	}
+
+	out, err := json.Marshal(snap)
+	if err != nil {
+		return
+	}
+	file := snapshotPath(c.path, projectPath)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return
+	}
+	if err := writeFileAtomic(file, out, 0600); err != nil {
+		return // A cache: the next load just does the work again
+	}
+	lastSnapshot.Lock()
+	lastSnapshot.file, lastSnapshot.snap = file, snap
+	lastSnapshot.Unlock()
+}
+
+func cloneMap[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]V, len(m))
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
@@ -0,0 +1,539 @@
+// store.go - Mapping store: every real -> pseudonym pair plus where it came from.
+// Lives in mappings.json next to sanitizer.json, so config stays hand-editable
+// and discovered values can be audited (what kind, first seen where, how often).
+// Manual mappings stay in sanitizer.json; the store only tracks their sightings.
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mapping origins
+const (
+	OriginManual = "manual" // mappingsManual in sanitizer.json
+	OriginAuto   = "auto"   // Found by a detector
+	OriginImport = "import" // Loaded from another machine or project
+)
+
+// MappingEntry is one real value and everything known about it.
+type MappingEntry struct {
+	Real      string    `json:"real"`
+	Pseudonym string    `json:"pseudonym"`
+	Kind      string    `json:"kind"`   // ip, hostname, or a secretPatterns kind
+	Origin    string    `json:"origin"` // manual, auto or import
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Source    string    `json:"source,omitempty"`  // File (project-relative) or tool that first contained it
+	Project   string    `json:"project,omitempty"` // ID of the project it was first seen in (see project.go)
+	Scope     string    `json:"scope,omitempty"`   // Project ID it is limited to; empty = every project
+	Hits      int       `json:"hits"`              // Times a detector found it in files or tool output
+	Retired   []string  `json:"retired,omitempty"` // Earlier pseudonyms from rotation; still restore to Real
+}
+
+// MappingStore is the on-disk mappings.json. Entries are kept sorted by real
+// value so the file diffs cleanly.
+type MappingStore struct {
+	Version  int            `json:"version"`
+	Counters map[string]int `json:"counters,omitempty"`
+	Entries  []MappingEntry `json:"entries"`
+
+	key *storeKey // Set when encrypted (see crypt.go); SaveTo encrypts with it
+}
+
+const mappingStoreVersion = 1
+
+// MappingQuery filters store entries. Zero fields match everything.
+type MappingQuery struct {
+	Kind     string
+	Origin   string
+	Project  string    // Project ID or folder name; matches where first seen or scoped to
+	Contains string    // Case-insensitive substring of real, pseudonym or source
+	Since    time.Time // LastSeen at or after
+	Before   time.Time // LastSeen before
+	Values   []string  // Exact real values
+}
+
+// ErrMappingsNotSaved is wrapped by the errors of SaveAutoMappings.
+var ErrMappingsNotSaved = errors.New("mappings not saved")
+
+// sighting is a detector match recorded by DiscoverSensitiveValues, saved with the mappings.
+type sighting struct {
+	kind   string
+	source string
+	hits   int
+}
+
+func MappingStorePath() string {
+	return mappingStorePathFor(ConfigPath())
+}
+
+// mappingStorePathFor keeps the store next to whichever config file is in use.
+func mappingStorePathFor(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "mappings.json")
+}
+
+// LoadMappingStore reads the store next to this config.
+func (c *Config) LoadMappingStore() (*MappingStore, error) {
+	return LoadMappingStoreFrom(mappingStorePathFor(c.path), c.Encryption)
+}
+
+// LoadMappingStoreFrom reads the store, returning an empty one if it doesn't exist.
+// An encrypted store is decrypted in memory with the key source named in its
+// envelope; enc only supplies the key file location.
+func LoadMappingStoreFrom(path string, enc StoreEncryption) (*MappingStore, error) {
+	store := &MappingStore{Version: mappingStoreVersion, Counters: make(map[string]int)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+	data = stripBOM(data)
+
+	if env, ok := parseEnvelope(data); ok {
+		if store.key, err = enc.openKey(env.Encrypted); err != nil {
+			return nil, err
+		}
+		if data, err = openStore(env, store.key); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, err
+	}
+	if store.Version > mappingStoreVersion {
+		// Saving would drop whatever the newer release added
+		return nil, fmt.Errorf("%s is version %d, this sanitizer reads up to version %d: update the sanitizer", filepath.Base(path), store.Version, mappingStoreVersion)
+	}
+	if store.Counters == nil {
+		store.Counters = make(map[string]int)
+	}
+	return store, nil
+}
+
+// Get returns the entry for a real value in a scope ("" = global).
+func (s *MappingStore) Get(real, scope string) (*MappingEntry, bool) {
+	for i := range s.Entries {
+		if s.Entries[i].Real == real && s.Entries[i].Scope == scope {
+			return &s.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Query returns copies of the entries matching q, sorted by real value.
+func (s *MappingStore) Query(q MappingQuery) []MappingEntry {
+	var out []MappingEntry
+	for _, e := range s.Entries {
+		if q.Matches(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Matches reports whether e passes every filter set in q.
+func (q MappingQuery) Matches(e MappingEntry) bool {
+	if q.Kind != "" && !strings.EqualFold(e.Kind, q.Kind) ||
+		q.Origin != "" && !strings.EqualFold(e.Origin, q.Origin) ||
+		q.Project != "" && !matchesProject(e.Project, q.Project) && !matchesProject(e.Scope, q.Project) ||
+		!q.Since.IsZero() && e.LastSeen.Before(q.Since) ||
+		!q.Before.IsZero() && !e.LastSeen.Before(q.Before) {
+		return false
+	}
+	if len(q.Values) > 0 && !slices.Contains(q.Values, e.Real) {
+		return false
+	}
+	contains := strings.ToLower(q.Contains)
+	return contains == "" || strings.Contains(strings.ToLower(e.Real+"\x00"+e.Pseudonym+"\x00"+e.Source), contains)
+}
+
+// MappingsFor returns real -> pseudonym for the entries the sanitizer applies
+// automatically (auto and import; manual ones come from sanitizer.json) in a
+// scope: global entries, overridden by the scope's own ("" = global only).
+func (s *MappingStore) MappingsFor(scope string) map[string]string {
+	mappings := make(map[string]string)
+	for _, e := range s.Entries {
+		if e.Origin != OriginManual && e.Scope == "" {
+			mappings[e.Real] = e.Pseudonym
+		}
+	}
+	if scope != "" {
+		for _, e := range s.Entries {
+			if e.Origin != OriginManual && e.Scope == scope {
+				mappings[e.Real] = e.Pseudonym
+			}
+		}
+	}
+	return mappings
+}
+
+// RetiredFor returns rotated-out pseudonym -> real value for the entries
+// MappingsFor(scope) applies.
+func (s *MappingStore) RetiredFor(scope string) map[string]string {
+	retired := make(map[string]string)
+	for _, e := range s.Entries {
+		if e.Origin != OriginManual && (e.Scope == "" || e.Scope == scope) {
+			for _, old := range e.Retired {
+				retired[old] = e.Real
+			}
+		}
+	}
+	return retired
+}
+
+// SaveTo writes the store with entries sorted, readable only by the current user.
+// Encrypted stores stay encrypted with the same key.
+func (s *MappingStore) SaveTo(path string) error {
+	s.Version = mappingStoreVersion
+	sort.Slice(s.Entries, func(i, j int) bool { return s.Entries[i].Real < s.Entries[j].Real })
+
+	out, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	if s.key != nil {
+		if out, err = sealStore(out, s.key); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, out, 0600)
+}
+
+// MappingEntries returns the store plus manual mappings that have no store
+// entry yet, so a listing covers everything the sanitizer replaces. Sightings
+// not yet folded into the store are counted too.
+func (c *Config) MappingEntries() (*MappingStore, error) {
+	store, err := c.LoadMappingStore()
+	if err != nil {
+		return nil, err
+	}
+	applySightingsLog(store, mappingStorePathFor(c.path))
+	for real, pseudonym := range c.MappingsManual {
+		scope := c.manualScope(real)
+		if _, ok := store.Get(real, scope); !ok {
+			store.Entries = append(store.Entries, MappingEntry{
+				Real: real, Pseudonym: pseudonym, Kind: c.ClassifyKind(real), Origin: OriginManual, Scope: scope,
+			})
+		}
+	}
+	sort.Slice(store.Entries, func(i, j int) bool { return store.Entries[i].Real < store.Entries[j].Real })
+	return store, nil
+}
+
+// ClassifyKind names the detector that matches value exactly, for entries
+// whose kind wasn't recorded (migrated or manual). "other" if none does.
+func (c *Config) ClassifyKind(value string) string {
+	return classifyKind(c.Detectors(), value)
+}
+
+func classifyKind(detectors []Detector, value string) string {
+	for _, d := range detectors {
+		for _, match := range d.Find(value) {
+			if match == value {
+				return d.Kind
+			}
+		}
+	}
+	return "other"
+}
+
+// recordSighting notes that a detector found value in source.
+func (c *Config) recordSighting(value, kind, source string) {
+	if c.sightings == nil {
+		c.sightings = make(map[string]*sighting)
+	}
+	s, ok := c.sightings[value]
+	if !ok {
+		s = &sighting{kind: kind, source: source}
+		c.sightings[value] = s
+	}
+	s.hits++
+}
+
+// recordRestored notes the real values whose pseudonyms appear in text that
+// is being unsanitized, so PruneMappings knows they are still in use.
+func (c *Config) recordRestored(text string, reverseMappings map[string]string) {
+	for pseudonym, real := range reverseMappings {
+		if strings.Contains(text, pseudonym) {
+			if c.restored == nil {
+				c.restored = make(map[string]bool)
+			}
+			c.restored[real] = true
+		}
+	}
+}
+
+// SaveAutoMappings merges new auto mappings, template counters and the
+// sightings recorded since the last save into the store, and returns the auto
+// mappings as they now stand on disk. Callers must sanitize with the returned
+// map: another hook may have saved a different pseudonym for the same value
+// first, and that one wins because it may already have been sent to Claude.
+// On error (wrapping ErrMappingsNotSaved) the input is returned unchanged and
+// callers must not sanitize with it: a pseudonym Claude sees that isn't in the
+// store can never be restored.
+//
+// When nothing is new, the sightings only go to the log (see sightings.go).
+func (c *Config) SaveAutoMappings(autoMappings map[string]string) (map[string]string, error) {
+	return c.saveAutoMappings(autoMappings, false)
+}
+
+// FlushMappingStore saves what SaveAutoMappings would and folds the sightings
+// log into the store. Session stop calls it, so lastSeen is current for gc.
+func (c *Config) FlushMappingStore() error {
+	_, err := c.saveAutoMappings(c.MappingsAuto, true)
+	return err
+}
+
+func (c *Config) saveAutoMappings(autoMappings map[string]string, flush bool) (map[string]string, error) {
+	// Only values new since load are written; everything else is already on disk
+	// (or was removed from it on purpose, and must not come back)
+	pending := make(map[string]string)
+	for real, pseudonym := range autoMappings {
+		if c.MappingsAuto[real] != pseudonym {
+			pending[real] = pseudonym
+		}
+	}
+	for real, pseudonym := range c.legacyAuto {
+		if _, ok := pending[real]; !ok {
+			pending[real] = pseudonym
+		}
+	}
+	if len(pending) == 0 && c.legacyAuto == nil && !flush {
+		if len(c.sightings) == 0 && len(c.restored) == 0 {
+			return autoMappings, nil
+		}
+		logged, err := c.appendSightings()
+		if err != nil {
+			// Bookkeeping only: the mappings themselves are all on disk
+			log.Printf("sanitizer: could not log sightings: %v", err)
+			return autoMappings, nil
+		}
+		if logged && !sightingsLogFull(mappingStorePathFor(c.path)) {
+			return autoMappings, nil
+		}
+	}
+
+	merged, err := c.saveMappingStore(pending)
+	if err != nil {
+		return autoMappings, fmt.Errorf("%w: %v", ErrMappingsNotSaved, err)
+	}
+	c.sightings = nil
+	c.restored = nil
+	c.MappingsAuto = merged
+	if c.legacyAuto != nil && fileWritable(c.path) {
+		// Now in the store - drop the copies from sanitizer.json. A read-only
+		// one keeps them ("config validate" reports it); they're in the store.
+		if err := removeLegacyMappings(c.path); err != nil {
+			log.Printf("sanitizer: mappingsAuto is in the store but still in %s: %v", c.path, err)
+		}
+	}
+	c.legacyAuto = nil
+	return merged, nil
+}
+
+// saveMappingStore re-reads the store under its lock and merges pending into
+// it, so concurrent hooks never drop each other's entries. Conflicts with what
+// another hook saved since this one loaded are resolved in favour of the store:
+//   - same real value, different pseudonym: the stored pseudonym is kept
+//   - same pseudonym, different real value (both issued server-007 from the
+//     same counter): this value gets a fresh pseudonym
+//
+// The sightings log is folded in on the way. Returns the store's auto mappings
+// after the merge.
+func (c *Config) saveMappingStore(pending map[string]string) (map[string]string, error) {
+	path := mappingStorePathFor(c.path)
+	lock, err := acquireLock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLock(lock)
+
+	store, err := LoadMappingStoreFrom(path, c.Encryption)
+	if err != nil {
+		return nil, err // Includes a missing key: never overwrite a store we can't read
+	}
+	if store.key == nil && c.Encryption.Key != "" {
+		if store.key, err = c.Encryption.newKey(c.Encryption.Key); err != nil {
+			return nil, err
+		}
+	}
+	applySightingsLog(store, path)
+
+	// Counters never go backwards; merge first so re-issued pseudonyms start past both
+	for kind, n := range store.Counters {
+		if n > c.Counters[kind] {
+			c.Counters[kind] = n
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	detectors := c.Detectors()
+	index := make(map[string]int, len(store.Entries)) // scope + "\x00" + real -> entry
+	key := func(scope, real string) string { return scope + "\x00" + real }
+	// Pseudonyms are unique across all scopes, so a global value added later
+	// can never collide with one a project already uses
+	used := make(map[string]bool, len(store.Entries)+len(c.MappingsManual))
+	for i, e := range store.Entries {
+		index[key(e.Scope, e.Real)] = i
+		used[e.Pseudonym] = true
+		for _, old := range e.Retired {
+			used[old] = true
+		}
+	}
+	for _, pseudonym := range c.MappingsManual {
+		used[pseudonym] = true
+	}
+	// lookup finds the entry this project sees for real: its own scope, then global
+	lookup := func(real string) (int, bool) {
+		if i, ok := index[key(c.scope, real)]; ok {
+			return i, true
+		}
+		i, ok := index[key("", real)]
+		return i, ok
+	}
+	add := func(real, pseudonym, origin, scope string) *MappingEntry {
+		e := MappingEntry{Real: real, Pseudonym: pseudonym, Origin: origin, Scope: scope, FirstSeen: now, LastSeen: now}
+		if s, ok := c.sightings[real]; ok {
+			e.Kind, e.Source, e.Project = s.kind, s.source, c.projectID
+		} else {
+			e.Kind = classifyKind(detectors, real) // Migrated from mappingsAuto - origin unknown
+		}
+		store.Entries = append(store.Entries, e)
+		index[key(scope, real)] = len(store.Entries) - 1
+		return &store.Entries[len(store.Entries)-1]
+	}
+
+	// Sorted so re-issued pseudonyms don't depend on map order
+	reals := make([]string, 0, len(pending))
+	for real := range pending {
+		reals = append(reals, real)
+	}
+	sort.Strings(reals)
+
+	conflicts := 0
+	for _, real := range reals {
+		pseudonym := pending[real]
+		if i, ok := lookup(real); ok {
+			if store.Entries[i].Pseudonym != pseudonym {
+				conflicts++ // Saved first by another hook - keep theirs
+			}
+			continue
+		}
+		if used[pseudonym] {
+			conflicts++
+			kind := classifyKind(detectors, real)
+			if s, ok := c.sightings[real]; ok {
+				kind = s.kind
+			}
+			pseudonym = c.NewPseudonym(kind, used)
+		}
+		used[pseudonym] = true
+		scope := c.scope
+		if _, ok := c.legacyAuto[real]; ok {
+			scope = "" // mappingsAuto was global
+		}
+		add(real, pseudonym, OriginAuto, scope)
+	}
+	if conflicts > 0 {
+		// Counts only: stderr can end up in front of Claude, real values must not
+		log.Printf("sanitizer: resolved %d mapping conflict(s) with a concurrent save", conflicts)
+	}
+
+	for real, s := range c.sightings {
+		var e *MappingEntry
+		if pseudonym, ok := c.MappingsManual[real]; ok {
+			scope := c.manualScope(real)
+			if i, ok := index[key(scope, real)]; ok {
+				e = &store.Entries[i]
+				e.Pseudonym = pseudonym // May have been edited in sanitizer.json since last seen
+			} else {
+				e = add(real, pseudonym, OriginManual, scope)
+			}
+		} else if i, ok := lookup(real); ok {
+			e = &store.Entries[i]
+		} else {
+			continue
+		}
+		e.Hits += s.hits
+		e.LastSeen = now
+	}
+
+	// Restored from a pseudonym at session stop: still in use, so gc keeps it
+	for real := range c.restored {
+		i, ok := lookup(real)
+		if _, manual := c.MappingsManual[real]; manual {
+			i, ok = index[key(c.manualScope(real), real)]
+		}
+		if ok {
+			store.Entries[i].LastSeen = now
+		}
+	}
+
+	// Manual entries whose mapping was removed from sanitizer.json are stale.
+	// Another project's manual entries aren't in this config - leave them.
+	kept := store.Entries[:0]
+	for _, e := range store.Entries {
+		if e.Origin == OriginManual && (e.Scope == "" || e.Scope == c.projectID) {
+			if _, ok := c.MappingsManual[e.Real]; !ok || c.manualScope(e.Real) != e.Scope {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	store.Entries = kept
+
+	for kind, n := range c.Counters {
+		if n > store.Counters[kind] {
+			store.Counters[kind] = n
+		}
+	}
+	if err := store.SaveTo(path); err != nil {
+		return nil, err
+	}
+	removeSightingsLog(path)
+	return store.MappingsFor(c.scope), nil
+}
+
+// removeLegacyMappings drops mappingsAuto and counters from sanitizer.json
+// once they have been copied into the store, keeping every other field.
+func removeLegacyMappings(configPath string) error {
+	return updateConfigFile(configPath, func(raw map[string]any) {
+		delete(raw, "mappingsAuto")
+		delete(raw, "counters")
+	})
+}
+
+// ProjectRelative returns path relative to the project (working directory)
+// with forward slashes, or path unchanged if it's outside the project.
+func ProjectRelative(path string) string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// manualScope is the store scope of a manual mapping: the project's ID if it
+// comes from the project's "projects" entry, else global.
+func (c *Config) manualScope(real string) string {
+	if c.projectManual[real] {
+		return c.projectID
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+// template.go - Sequential pseudonym templates.
+// Turns a per-kind template like "server-{n:03}.corp.example" and a counter
+// into readable, predictable sanitized values.
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// Matches {n}, {n:03}, {n/256}, {n%256} and chains like {n/256%256:02}.
+// Group 1 = arithmetic chain, group 2 = zero-pad width.
+var templatePlaceholder = regexp.MustCompile(`\{n((?:[/%][0-9]+)*)(?::0?([0-9]+))?\}`)
+
+// Splits an arithmetic chain like "/256%256" into ("/", "256"), ("%", "256").
+var templateOp = regexp.MustCompile(`([/%])([0-9]+)`)
+
+// RenderTemplate expands every {n...} placeholder in tmpl using counter n.
+// Operators apply left to right: {n/256%256} = (n / 256) % 256.
+func RenderTemplate(tmpl string, n int) (string, error) {
+	var renderErr error
+	out := templatePlaceholder.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		parts := templatePlaceholder.FindStringSubmatch(placeholder)
+		v := n
+		for _, op := range templateOp.FindAllStringSubmatch(parts[1], -1) {
+			operand, _ := strconv.Atoi(op[2])
+			if operand == 0 {
+				renderErr = fmt.Errorf("template %q: division by zero", tmpl)
+				return placeholder
+			}
+			if op[1] == "/" {
+				v /= operand
+			} else {
+				v %= operand
+			}
+		}
+		if parts[2] != "" {
+			width, _ := strconv.Atoi(parts[2])
+			return fmt.Sprintf("%0*d", width, v)
+		}
+		return strconv.Itoa(v)
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+	return out, nil
+}
+
+// maxTemplateAttempts bounds the counter search when rendered values collide
+// with pseudonyms already in use (manual mappings, earlier runs).
+const maxTemplateAttempts = 100000
+
+// NewPseudonym returns a fresh sanitized value of the given kind ("ip",
+// "hostname", or a secret kind set to pseudonymize) that is not in used. If
+// pseudonymTemplates has an entry for the kind, the kind's counter is advanced
+// and the template rendered; otherwise a random value is generated. Caller
+// must save cfg.Counters with the mappings.
+func (c *Config) NewPseudonym(kind string, used map[string]bool) string {
+	if tmpl, ok := c.PseudonymTemplates[kind]; ok && tmpl != "" {
+		if c.Counters == nil {
+			c.Counters = make(map[string]int)
+		}
+		var ips *ipFilter
+		if kind == "ip" {
+			ips = c.newIPFilter()
+		}
+		for i := 0; i < maxTemplateAttempts; i++ {
+			c.Counters[kind]++
+			v, err := RenderTemplate(tmpl, c.Counters[kind])
+			if err != nil {
+				break // Bad template - fall back to random values
+			}
+			// An IP template that overflows (10.99.256.1) must not produce junk,
+			// nor an address the filter hides: a real IP equal to it would be
+			// taken for a pseudonym, never hidden, and restored to the wrong value
+			if ips != nil && (net.ParseIP(v) == nil || ips.hides(v)) {
+				break
+			}
+			if !used[v] {
+				return v
+			}
+		}
+	}
+
+	var newValue func() string
+	switch kind {
+	case "ip":
+		newValue = NewSanitizedIP
+	case "hostname":
+		newValue = NewSanitizedHostname
+	default:
+		// Pseudonymized secrets get an opaque per-kind value like password-1a2b3c4d
+		newValue = func() string { return fmt.Sprintf("%s-%08x", kind, rand.Uint32()) }
+	}
+	v := newValue()
+	for used[v] {
+		v = newValue()
+	}
+	return v
+}
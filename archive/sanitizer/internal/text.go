@@ -2,7 +2,6 @@
 package internal
 
 import (
-	"regexp"
 	"sort"
 	"strings"
 )
@@ -13,15 +12,26 @@ import (
 // replace "111.135.16.104" first, otherwise "111.14.33.209" would match and
 // leave a stray "0" behind.
 func SanitizeText(text string, mappings map[string]string) string {
+	return newReplacer(mappings)(text)
+}
+
+// newReplacer builds the matcher for SanitizeText once, so callers applying
+// the same mappings to many small strings (one per JSON value) don't rebuild it.
+// strings.Replacer walks the text once whatever the number of mappings, and
+// at each position takes the first key in argument order that matches, so
+// passing keys longest first keeps the longest-match rule.
+func newReplacer(mappings map[string]string) func(string) string {
 	if len(mappings) == 0 {
-		return text
+		return func(text string) string { return text }
 	}
 
 	// Extract keys into slice for sorting.
 	// make([]string, 0, len(mappings)) pre-allocates capacity = slight perf gain.
 	keys := make([]string, 0, len(mappings))
 	for k := range mappings {
-		keys = append(keys, k)
+		if k != "" { // An empty key would be inserted between every character
+			keys = append(keys, k)
+		}
 	}
 
 	// Sort by length descending. sort.Slice takes a "less" function.
@@ -30,10 +40,11 @@ func SanitizeText(text string, mappings map[string]string) string {
 		return len(keys[i]) > len(keys[j])
 	})
 
+	pairs := make([]string, 0, 2*len(keys))
 	for _, key := range keys {
-		text = strings.ReplaceAll(text, key, mappings[key])
+		pairs = append(pairs, key, mappings[key])
 	}
-	return text
+	return strings.NewReplacer(pairs...).Replace
 }
 
 // UnsanitizeText reverses sanitization. Same algorithm, just pass reversed mappings.
@@ -42,12 +53,29 @@ func UnsanitizeText(text string, reverseMappings map[string]string) string {
 	return SanitizeText(text, reverseMappings)
 }
 
-// DiscoverSensitiveValues scans text for IPs and hostnames not yet in mappings.
+// DiscoverSensitiveValues scans text for sensitive values not yet in mappings.
 // Returns new mappings only - caller should merge with existing and save.
+// Only kinds with the pseudonymize policy are returned; redacted and hashed
+// kinds are handled by Config.Redactions and never stored.
+//
+// Generates sanitized values (templated or random, see NewPseudonym) with
+// collision detection - no two real values will map to the same sanitized value.
+// Advances cfg.Counters when templates are used.
 //
-// Generates random sanitized values with collision detection - no two real
-// values will map to the same sanitized value.
-func DiscoverSensitiveValues(text string, cfg *Config) map[string]string {
+// Every match, new or known, is recorded as a sighting from source (a
+// project-relative file or a tool name) for the mapping store's audit trail.
+func DiscoverSensitiveValues(text string, cfg *Config, source string) map[string]string {
+	return discover(text, cfg, source, cfg.Detectors())
+}
+
+// DiscoverFileValues is DiscoverSensitiveValues for the content of the file at
+// path, with the detectors its pathRules select (see pathrules.go).
+func DiscoverFileValues(text string, cfg *Config, path string) map[string]string {
+	relPath := ProjectRelative(path)
+	return discover(text, cfg, relPath, cfg.DetectorsFor(relPath))
+}
+
+func discover(text string, cfg *Config, source string, detectors []Detector) map[string]string {
 	discovered := make(map[string]string)
 
 	// Track all used sanitized values to prevent collisions
@@ -58,41 +86,26 @@ func DiscoverSensitiveValues(text string, cfg *Config) map[string]string {
 	for _, v := range cfg.MappingsAuto {
 		usedValues[v] = true
 	}
-
-	// Find all IPv4 addresses
-	ipRegex := IPv4Regex()
-	for _, ip := range ipRegex.FindAllString(text, -1) {
-		if !IsExcludedIP(ip) {
-			if _, exists := cfg.MappingsManual[ip]; !exists {
-				if _, exists := cfg.MappingsAuto[ip]; !exists {
-					if _, exists := discovered[ip]; !exists {
-						// Retry until we get a unique sanitized value
-						sanitized := NewSanitizedIP()
-						for usedValues[sanitized] {
-							sanitized = NewSanitizedIP()
-						}
-						discovered[ip] = sanitized
-						usedValues[sanitized] = true
-					}
-				}
-			}
-		}
+	for v := range cfg.retired {
+		usedValues[v] = true // Never re-issued, still restores to its old value
 	}
 
-	// Find hostnames matching configured patterns (user has full regex control).
-	for _, pattern := range cfg.HostnamePatterns {
-		re, err := regexp.Compile(`(?i)` + pattern)
-		if err != nil {
+	// Detectors run in order: IPs, hostnames, then secrets.
+	// usedValues check skips our own pseudonyms - templated values like
+	// 10.99.0.1 or server-001.corp.example can match the detectors too.
+	for _, detector := range detectors {
+		if cfg.PolicyFor(detector.Kind) != PolicyPseudonymize {
 			continue
 		}
-		for _, match := range re.FindAllString(text, -1) {
+		for _, match := range detector.Find(text) {
+			if usedValues[match] {
+				continue
+			}
+			cfg.recordSighting(match, detector.Kind, source)
 			if _, exists := cfg.MappingsManual[match]; !exists {
 				if _, exists := cfg.MappingsAuto[match]; !exists {
 					if _, exists := discovered[match]; !exists {
-						sanitized := NewSanitizedHostname()
-						for usedValues[sanitized] {
-							sanitized = NewSanitizedHostname()
-						}
+						sanitized := cfg.NewPseudonym(detector.Kind, usedValues)
 						discovered[match] = sanitized
 						usedValues[sanitized] = true
 					}
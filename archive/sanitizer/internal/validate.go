@@ -0,0 +1,526 @@
+// validate.go - Config and mapping checks, for "sanitizer config validate" and,
+// the cheap ones only, for every load.
+// Hooks skip what they can't use (a bad regex is ignored, not fatal), so
+// without these checks a typo in a hostname pattern just means hostnames
+// quietly stop being sanitized.
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigProblem is one thing wrong with sanitizer.json or the mapping store.
+type ConfigProblem struct {
+	File    string // sanitizer.json or mappings.json
+	Line    int    // 1-based position of the setting; 0 if unknown (encrypted store)
+	Column  int
+	Path    string // JSON path of the setting: hostnamePatterns[2], mappingsManual["db01"]
+	Message string
+}
+
+// Location is where the problem is, without the JSON path: paths can hold
+// real values (mappingsManual keys), locations never do.
+func (p ConfigProblem) Location() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	if strings.HasPrefix(p.Path, "entries[") {
+		return p.File + " " + p.Path[:strings.Index(p.Path, "]")+1]
+	}
+	return p.File
+}
+
+// String formats the problem like a compiler error:
+// sanitizer.json:4:9: hostnamePatterns[2]: invalid regex: missing closing )
+func (p ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Location(), p.Path, p.Message)
+}
+
+// Validate checks the config and the mapping store as the project in the
+// working directory sees them. Problems are sorted by file and position.
+func (c *Config) Validate() ([]ConfigProblem, error) {
+	store, err := c.LoadMappingStore()
+	if err != nil {
+		return nil, err
+	}
+	storeData, err := os.ReadFile(mappingStorePathFor(c.path))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if _, ok := parseEnvelope(storeData); ok {
+		storeData = nil // Encrypted: entries are reported by index only
+	}
+	problems := c.validate(store, stripBOM(storeData), true)
+	if p, ok := c.checkReadOnlyUpgrade(); ok {
+		problems = append([]ConfigProblem{p}, problems...)
+	}
+	return problems, nil
+}
+
+// checkReadOnlyUpgrade reports a global sanitizer.json from an older version
+// that loading can't write back: hooks skip the write rather than retrying
+// it every call, so the upgrade is redone in memory each time.
+func (c *Config) checkReadOnlyUpgrade() (ConfigProblem, bool) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return ConfigProblem{}, false
+	}
+	var raw map[string]any
+	if json.Unmarshal(stripBOM(data), &raw) != nil {
+		return ConfigProblem{}, false
+	}
+	from, err := configVersion(raw)
+	legacyAuto, _ := raw["mappingsAuto"].(map[string]any)
+	counters, _ := raw["counters"].(map[string]any)
+	upgraded := from >= ConfigVersion && len(legacyAuto) == 0 && len(counters) == 0
+	if err != nil || upgraded || fileWritable(c.path) {
+		return ConfigProblem{}, false
+	}
+	return ConfigProblem{
+		File:    filepath.Base(c.path),
+		Path:    "version",
+		Message: "older layout not upgraded on disk because the file isn't writable: it is upgraded in memory on every load; make it writable once",
+	}, true
+}
+
+// validate runs the checks. Settings are located in whichever layer set them
+// (see layers.go); storeData is only used for line numbers and may be nil.
+// Loading runs it without full: regexes (compiled once, and kept for the
+// detectors) and enum values only. The mapping conflict scan grows with the
+// store and the shell lookups search PATH, so only "config validate" pays.
+func (c *Config) validate(store *MappingStore, storeData []byte, full bool) []ConfigProblem {
+	var problems []ConfigProblem
+	report := func(path, format string, args ...any) {
+		p := ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...)}
+		p.File, p.Line, p.Column = c.locate(path)
+		problems = append(problems, p)
+	}
+
+	for _, l := range c.layers {
+		for _, r := range l.rejected {
+			line, column := lineColumn(l.data, l.offsets, r.path)
+			problems = append(problems, ConfigProblem{File: l.name, Line: line, Column: column, Path: r.path, Message: r.reason})
+		}
+	}
+	for i, pattern := range c.HostnamePatterns {
+		checkPattern(report, jsonPath("hostnamePatterns", i), `(?i)`+pattern)
+	}
+	for _, kind := range sortedKeys(c.SecretPatterns) {
+		checkPattern(report, jsonPath("secretPatterns", kind), c.SecretPatterns[kind])
+	}
+	for i, rule := range c.PathRules {
+		c.validatePathRule(report, i, rule)
+	}
+	for i, route := range c.BashRoutes {
+		validateBashRoute(report, i, route, full)
+	}
+	for i, pattern := range c.BlockedPaths {
+		if _, err := compileCached(pattern); err != nil {
+			report(jsonPath("blockedPaths", i), "invalid regex, pattern is ignored: %v", regexError(err))
+		}
+	}
+
+	for _, list := range []struct {
+		name    string
+		entries []string
+	}{{"ipInclude", c.IPInclude}, {"ipExclude", c.IPExclude}} {
+		for i, entry := range list.entries {
+			p, err := parseIPPrefix(entry)
+			switch {
+			case err != nil && list.name == "ipInclude":
+				report(jsonPath(list.name, i), "not a CIDR or IP address, the allowlist is off and every IP is sanitized: %q", entry)
+			case err != nil:
+				report(jsonPath(list.name, i), "not a CIDR or IP address, entry is ignored: %q", entry)
+			case list.name == "ipInclude" && sanitizedIPRange.Overlaps(p):
+				report(jsonPath(list.name, i), "overlaps %s, where pseudonyms come from: those IPs are never sanitized", sanitizedIPRange)
+			}
+		}
+	}
+
+	for _, kind := range sortedKeys(c.Policies) {
+		switch c.Policies[kind] {
+		case "", PolicyPseudonymize, PolicyRedact, PolicyHash:
+		default:
+			report(jsonPath("policies", kind), "unknown policy %q (treated as %s); use %s, %s or %s",
+				c.Policies[kind], PolicyRedact, PolicyPseudonymize, PolicyRedact, PolicyHash)
+		}
+	}
+	for _, kind := range sortedKeys(c.PseudonymTemplates) {
+		tmpl := c.PseudonymTemplates[kind]
+		v, err := RenderTemplate(tmpl, 1)
+		switch {
+		case err != nil:
+			report(jsonPath("pseudonymTemplates", kind), "%v", err)
+		case !templatePlaceholder.MatchString(tmpl):
+			report(jsonPath("pseudonymTemplates", kind), "no {n} placeholder: every value would get the same pseudonym")
+		case kind == "ip" && c.newIPFilter().hides(v):
+			report(jsonPath("pseudonymTemplates", kind), "renders %s, an IP that is sanitized: it could equal a real one, so random values are used instead; add the template's range to ipExclude", v)
+		}
+	}
+	switch c.NotebookOutputs {
+	case "", NotebookOutputsKeep, NotebookOutputsStrip, NotebookOutputsRedact:
+	default:
+		report("notebookOutputs", "unknown value %q; use %s, %s or %s",
+			c.NotebookOutputs, NotebookOutputsKeep, NotebookOutputsStrip, NotebookOutputsRedact)
+	}
+	switch c.Encryption.Key {
+	case "", KeySourceKeyfile, KeySourceKeyring, KeySourcePassphrase:
+	default:
+		report("encryption.key", "unknown key source %q; use %s, %s or %s",
+			c.Encryption.Key, KeySourceKeyfile, KeySourceKeyring, KeySourcePassphrase)
+	}
+	checkProfile(report, "profile", c.Profile)
+	for _, path := range sortedKeys(c.Projects) {
+		checkProfile(report, jsonPath("projects", path, "profile"), c.Projects[path].Profile)
+	}
+	c.checkKinds(report, "disableDetectors", c.DisableDetectors)
+	checkFailureMode(report, "failureMode", c.FailureMode)
+	for _, hook := range sortedKeys(c.HookFailureModes) {
+		if !slices.Contains(FailureHooks, hook) {
+			report(jsonPath("hookFailureModes", hook), "unknown hook %q; use %s", hook, strings.Join(FailureHooks, ", "))
+		}
+		checkFailureMode(report, jsonPath("hookFailureModes", hook), c.HookFailureModes[hook])
+	}
+	checkScope(report, "mappingScope", c.MappingScope)
+	for _, path := range sortedKeys(c.Projects) {
+		checkScope(report, jsonPath("projects", path, "mappingScope"), c.Projects[path].MappingScope)
+	}
+
+	if full {
+		// Separate statement: report appends to problems while validateMappings runs
+		mappingProblems := c.validateMappings(report, store, storeData)
+		problems = append(problems, mappingProblems...)
+	}
+
+	// Config layers in merge order, then the store
+	rank := make(map[string]int, len(c.layers))
+	for i, l := range c.layers {
+		rank[l.name] = i
+	}
+	storeFile := filepath.Base(mappingStorePathFor(c.path))
+	rank[storeFile] = len(c.layers)
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.File != b.File {
+			return rank[a.File] < rank[b.File]
+		}
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return problems
+}
+
+// validatePathRule checks one pathRules entry: its globs, hostname patterns
+// and detector kinds.
+func (c *Config) validatePathRule(report func(path, format string, args ...any), i int, rule PathRule) {
+	if len(rule.Paths) == 0 {
+		report(jsonPath("pathRules", i), "no paths: the rule applies to no file")
+	}
+	for j, glob := range rule.Paths {
+		if err := globError(glob); err != nil {
+			report(jsonPath("pathRules", i, "paths", j), "invalid glob, matches no file: %v", err)
+		}
+	}
+	for j, pattern := range rule.HostnamePatterns {
+		checkPattern(report, jsonPath("pathRules", i, "hostnamePatterns", j), `(?i)`+pattern)
+	}
+	c.checkKinds(report, jsonPath("pathRules", i, "only"), rule.Only)
+	c.checkKinds(report, jsonPath("pathRules", i, "disable"), rule.Disable)
+}
+
+// checkKinds reports entries of a detector kind list that name no detector.
+func (c *Config) checkKinds(report func(path, format string, args ...any), path string, kinds []string) {
+	known := map[string]bool{"ip": true, "hostname": true, "privatekey": true, "card": true, KindSecrets: true}
+	for kind := range c.SecretPatterns {
+		known[kind] = true
+	}
+	for j, kind := range kinds {
+		if !known[kind] {
+			report(path+jsonPath(j), "unknown detector kind %q; use ip, hostname, privatekey, card, %s or a secretPatterns kind", kind, KindSecrets)
+		}
+	}
+}
+
+// validateBashRoute checks one bashRoutes entry: its regex, action and, when
+// full, that its shell is on PATH.
+func validateBashRoute(report func(path, format string, args ...any), i int, route BashRoute, full bool) {
+	if route.Match == "" {
+		report(jsonPath("bashRoutes", i), "no match regex: the route applies to no command")
+	} else if _, err := compileCached(route.Match); err != nil {
+		report(jsonPath("bashRoutes", i, "match"), "invalid regex, route is ignored: %v", regexError(err))
+	}
+	switch route.Action {
+	case RouteDeny, RouteAsk, RouteUnsanitized, RouteSanitized, RouteFiltered:
+	default:
+		report(jsonPath("bashRoutes", i, "action"), "unknown action %q (treated as %s); use %s, %s, %s, %s or %s",
+			route.Action, RouteDeny, RouteDeny, RouteAsk, RouteUnsanitized, RouteSanitized, RouteFiltered)
+	}
+	if route.Shell == "" {
+		return
+	}
+	if route.Action != RouteUnsanitized {
+		report(jsonPath("bashRoutes", i, "shell"), "shell only applies to %s; the command runs in Claude's shell", RouteUnsanitized)
+	} else if full {
+		if _, err := exec.LookPath(route.Shell); err != nil {
+			report(jsonPath("bashRoutes", i, "shell"), "%q not found on PATH: matching commands will fail", route.Shell)
+		}
+	}
+}
+
+// checkPattern reports a detector regex that doesn't compile, or that matches
+// the empty string (it would "find" an empty value at every position).
+func checkPattern(report func(path, format string, args ...any), path, pattern string) {
+	re, err := compileCached(pattern)
+	if err != nil {
+		report(path, "invalid regex, pattern is ignored: %v", regexError(err))
+	} else if re.MatchString("") {
+		report(path, "regex matches the empty string")
+	}
+}
+
+func checkProfile(report func(path, format string, args ...any), path, name string) {
+	if _, ok := profiles[name]; name != "" && !ok {
+		report(path, "unknown profile %q (treated as %s); use %s", name, ProfileStandard, strings.Join(ProfileNames(), ", "))
+	}
+}
+
+func checkFailureMode(report func(path, format string, args ...any), path, mode string) {
+	if mode != "" && mode != FailureOpen && mode != FailureClosed {
+		report(path, "unknown failure mode %q (treated as %s); use %s or %s", mode, FailureClosed, FailureOpen, FailureClosed)
+	}
+}
+
+func checkScope(report func(path, format string, args ...any), path, scope string) {
+	if scope != "" && !strings.EqualFold(scope, ScopeGlobal) && !strings.EqualFold(scope, ScopeProject) {
+		report(path, "unknown mapping scope %q (treated as %s); use %s or %s", scope, ScopeGlobal, ScopeGlobal, ScopeProject)
+	}
+}
+
+// regexError drops the "error parsing regexp: " prefix Go puts on every message.
+func regexError(err error) string {
+	return strings.TrimPrefix(err.Error(), "error parsing regexp: ")
+}
+
+// mappingClaim is one real -> pseudonym pair and where it is set.
+type mappingClaim struct {
+	real, pseudonym string
+	retired         bool
+	file            string // Store file; "" for config settings (located by path)
+	path            string
+}
+
+// validateMappings checks the mappings this project applies (see ReverseMappings):
+//   - two real values with one pseudonym: restoring it can only give one of them
+//   - a pseudonym that is another mapping's real value: the two rewrite each other
+//
+// Manual mappings are reported through report; store entries are returned,
+// located in storeData.
+func (c *Config) validateMappings(report func(path, format string, args ...any), store *MappingStore, storeData []byte) []ConfigProblem {
+	var claims []mappingClaim
+	for _, real := range sortedKeys(c.MappingsManual) {
+		path := jsonPath("mappingsManual", real)
+		if c.projectManual[real] {
+			for projectPath, p := range c.Projects {
+				if _, ok := p.MappingsManual[real]; ok && c.ProjectID(projectPath) == c.projectID {
+					path = jsonPath("projects", projectPath, "mappingsManual", real)
+				}
+			}
+		}
+		if real == "" {
+			report(path, "empty value: it would match everywhere")
+			continue
+		}
+		claims = append(claims, mappingClaim{real: real, pseudonym: c.MappingsManual[real], path: path})
+	}
+
+	storeFile := filepath.Base(mappingStorePathFor(c.path))
+	if store != nil {
+		for i, e := range store.Entries {
+			if e.Origin == OriginManual || e.Scope != "" && e.Scope != c.scope {
+				continue // Manual ones are checked above; other projects' scopes don't apply here
+			}
+			if _, manual := c.MappingsManual[e.Real]; manual {
+				continue // Overridden by the manual mapping
+			}
+			claims = append(claims, mappingClaim{real: e.Real, pseudonym: e.Pseudonym, file: storeFile, path: jsonPath("entries", i, "pseudonym")})
+			for j, old := range e.Retired {
+				claims = append(claims, mappingClaim{real: e.Real, pseudonym: old, retired: true, file: storeFile, path: jsonPath("entries", i, "retired", j)})
+			}
+		}
+	}
+
+	var problems []ConfigProblem
+	storeAt := jsonOffsets(storeData)
+	add := func(cl mappingClaim, format string, args ...any) {
+		if cl.file == "" {
+			report(cl.path, format, args...)
+			return
+		}
+		p := ConfigProblem{File: cl.file, Path: cl.path, Message: fmt.Sprintf(format, args...)}
+		p.Line, p.Column = lineColumn(storeData, storeAt, cl.path)
+		problems = append(problems, p)
+	}
+	describe := func(cl mappingClaim) string {
+		file, _, _ := c.locate(cl.path)
+		where := file + " " + cl.path
+		if cl.file == storeFile {
+			where = cl.file + " " + cl.path[:strings.Index(cl.path, "]")+1] // The entry, not its field
+		}
+		if cl.retired {
+			return where + " (retired)"
+		}
+		return where
+	}
+
+	byPseudonym := make(map[string]mappingClaim)
+	reals := make(map[string]mappingClaim)
+	for _, cl := range claims {
+		if _, ok := reals[cl.real]; !ok {
+			reals[cl.real] = cl
+		}
+	}
+	for _, cl := range claims {
+		if first, ok := byPseudonym[cl.pseudonym]; ok && first.real != cl.real {
+			add(cl, "pseudonym %q is also used for %s: restoring it can only give one value", cl.pseudonym, describe(first))
+		} else if !ok {
+			byPseudonym[cl.pseudonym] = cl
+		}
+		if other, ok := reals[cl.pseudonym]; ok && other.real != cl.real {
+			add(cl, "pseudonym %q is the real value of %s", cl.pseudonym, describe(other))
+		}
+	}
+	return problems
+}
+
+// jsonError adds the file, line and column to a JSON syntax or type error:
+// sanitizer.json:7:21: cannot unmarshal number into mappingsManual of type string
+func jsonError(path string, data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, column := lineColumn(data, map[string]int64{"": offset}, "")
+	return fmt.Errorf("%s:%d:%d: %w", filepath.Base(path), line, column, err)
+}
+
+// problemSummary is the load-time report: a count and locations only, since
+// hook stderr can reach Claude and JSON paths can hold real values.
+func problemSummary(problems []ConfigProblem) string {
+	locations := make([]string, 0, len(problems))
+	for _, p := range problems {
+		locations = append(locations, p.Location())
+	}
+	return fmt.Sprintf("sanitizer: %d config problem(s) at %s; run 'sanitizer config validate' for details",
+		len(problems), strings.Join(locations, ", "))
+}
+
+// jsonPath builds a path like projects["C:\\code\\api"].mappingScope from
+// keys (strings) and array indexes (ints).
+func jsonPath(parts ...any) string {
+	var b strings.Builder
+	for _, part := range parts {
+		switch v := part.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", v)
+		case string:
+			b.WriteString(jsonKey(b.Len() == 0, v))
+		}
+	}
+	return b.String()
+}
+
+var plainJSONKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func jsonKey(first bool, key string) string {
+	if !plainJSONKey.MatchString(key) {
+		return "[" + strconv.Quote(key) + "]"
+	}
+	if first {
+		return key
+	}
+	return "." + key
+}
+
+// jsonOffsets maps the JSON path of every key and array element in data to
+// its byte offset. Invalid JSON gives whatever was read before the error.
+func jsonOffsets(data []byte) map[string]int64 {
+	offsets := make(map[string]int64)
+	if len(data) == 0 {
+		return offsets
+	}
+	type frame struct {
+		path      string
+		array     bool
+		index     int
+		wantKey   bool
+		valuePath string
+	}
+	var stack []*frame
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		// InputOffset is just past the previous token; skip to the next one
+		pos := dec.InputOffset()
+		for pos < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[pos]) >= 0 {
+			pos++
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return offsets
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		path := ""
+		if n := len(stack); n > 0 {
+			top := stack[n-1]
+			switch {
+			case top.array:
+				path = top.path + jsonPath(top.index)
+				top.index++
+				offsets[path] = pos
+			case top.wantKey:
+				key, _ := tok.(string)
+				top.valuePath = top.path + jsonKey(top.path == "", key)
+				offsets[top.valuePath] = pos
+				top.wantKey = false
+				continue
+			default:
+				path = top.valuePath
+				top.wantKey = true
+			}
+		}
+		if d, ok := tok.(json.Delim); ok {
+			stack = append(stack, &frame{path: path, array: d == '[', wantKey: d == '{'})
+		}
+	}
+}
+
+// lineColumn returns the 1-based line and column of path in data, or 0, 0.
+func lineColumn(data []byte, offsets map[string]int64, path string) (int, int) {
+	pos, ok := offsets[path]
+	if !ok {
+		return 0, 0
+	}
+	before := data[:pos]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return line, int(pos) - bytes.LastIndexByte(before, '\n')
+}